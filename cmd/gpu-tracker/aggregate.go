@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"gpuwatch/internal/agent"
+	"gpuwatch/internal/api"
+	"gpuwatch/internal/fleet"
+	"gpuwatch/internal/stream"
+	"gpuwatch/internal/types"
+	"gpuwatch/internal/web"
+)
+
+// runAggregator stores snapshots pushed by -agent hosts into the database
+// and serves the dashboard, API and live streams for the whole fleet until
+// SIGINT/SIGTERM.
+func runAggregator(dbPath string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := openStore(dbPath)
+	if err != nil {
+		return fmt.Errorf("open db: %v", err)
+	}
+	defer db.Close()
+
+	hub := stream.NewHub(0)
+	a := api.New(db, nil, loc)
+	a.Fleet = fleet.Config{MaxTemp: *maxTemp, MaxMem: *maxMem}
+	a.MaxGap = maxGap()
+	mux := http.NewServeMux()
+	mux.Handle("POST "+agent.IngestPath, agent.Handler(db, *tokenFlag, func(s types.Snapshot) {
+		hub.Publish(s)
+		checkAlerts(s, *maxTemp, *maxMem)
+	}))
+	mux.HandleFunc("GET /api/v1/stream", hub.ServeSSE)
+	mux.HandleFunc("GET /api/v1/ws", hub.ServeWS)
+	mux.Handle("/api/", a)
+	mux.Handle("/", web.Handler(web.Config{Refresh: time.Duration(sampleInterval), MaxTemp: *maxTemp, MaxMem: *maxMem}))
+
+	srv := &http.Server{Addr: *aggregateAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context { return ctx }}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	if *tokenFlag == "" {
+		log.Printf("Warning: no -token set; any host that can reach %s can push samples", *aggregateAddr)
+	}
+	fmt.Printf("Aggregating into %s: agents push to http://%s%s, dashboard at http://%s/ (Ctrl+C to stop)\n",
+		dbPath, *aggregateAddr, agent.IngestPath, *aggregateAddr)
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// spoolDir is -spool, or a spool directory next to the SQLite database (in
+// the data directory for PostgreSQL).
+func spoolDir(dbPath string) (string, error) {
+	if *spoolFlag != "" {
+		return *spoolFlag, nil
+	}
+	if strings.Contains(dbPath, "://") { // PostgreSQL DSN
+		dir, err := ensureDataDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "spool"), nil
+	}
+	return filepath.Join(filepath.Dir(dbPath), "spool"), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gpuwatch/internal/ingest"
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// backupDB copies a SQLite history to dest with the online backup API.
+func backupDB(db store.Store, dest string) error {
+	sq, ok := db.(*store.DB)
+	if !ok {
+		return fmt.Errorf("-backup works on SQLite databases; use pg_dump for PostgreSQL")
+	}
+	if err := sq.Backup(dest); err != nil {
+		return err
+	}
+	fmt.Printf("Backed up to %s\n", dest)
+	return nil
+}
+
+// archiveDB writes the snapshots selected by -from/-to/-host to a portable
+// archive; without -from the whole history is exported.
+func archiveDB(db store.Store, path string) error {
+	from, to := time.Unix(0, 0), time.Now()
+	if *fromFlag != "" || *toFlag != "" {
+		var err error
+		if from, to, err = reportRange(); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	n, err := store.ExportArchive(w, db, from, to, *hostFlag)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	fmt.Printf("Archived %d snapshots to %s\n", n, path)
+	return nil
+}
+
+// restoreFile merges an archive or a SQLite backup into db. Snapshots without
+// host identity are attributed to the file name, as with -merge.
+func restoreFile(db store.Store, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, 16)
+	n, _ := f.Read(magic)
+	magic = magic[:n]
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+
+	name := filepath.Base(path)
+	for _, ext := range []string{".gz", ".jsonl", ".db"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	fallback := types.Host{Hostname: name}
+
+	var imported, skipped int
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		imported, skipped, err = store.ImportArchive(db, f, fallback)
+	case bytes.HasPrefix(magic, []byte("SQLite format 3\x00")):
+		f.Close()
+		src, oerr := store.OpenCopy(path) // never migrate the backup itself
+		if oerr != nil {
+			return oerr
+		}
+		if verr := store.ValidateAll(src); verr != nil {
+			src.Close()
+			return fmt.Errorf("%s: %w", path, verr)
+		}
+		imported, skipped, err = store.Merge(db, src, fallback)
+		src.Close()
+	default:
+		return fmt.Errorf("%s is neither a gpuwatch archive nor a SQLite database", path)
+	}
+	fmt.Printf("%s: imported %d snapshots, skipped %d duplicates\n", path, imported, skipped)
+	return err
+}
+
+// importFiles saves nvidia-smi XML logs or dcgm-exporter dumps into db,
+// skipping snapshots already stored. Recordings without a hostname are
+// attributed to -host, or else to the file name.
+func importFiles(db store.Store, format string, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("-import needs one or more files as arguments")
+	}
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		host := *hostFlag
+		if host == "" {
+			host = strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		}
+		var imported, skipped int
+		save := func(s types.Snapshot) error {
+			if s.Host.Hostname == "" {
+				s.Host.Hostname = host
+			}
+			_, inserted, err := db.ImportSnapshot(s)
+			if err != nil {
+				return err
+			}
+			if inserted {
+				imported++
+			} else {
+				skipped++
+			}
+			return nil
+		}
+		switch format {
+		case "nvidia-smi", "xml":
+			err = ingest.ReadNvidiaSMIXML(bufio.NewReader(f), loc, save)
+		case "dcgm":
+			var mtime time.Time
+			if st, serr := f.Stat(); serr == nil {
+				mtime = st.ModTime()
+			}
+			err = ingest.ReadDCGM(bufio.NewReader(f), mtime, save)
+		default:
+			err = fmt.Errorf("unknown import format: %s (supported: nvidia-smi, dcgm)", format)
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		fmt.Printf("%s: imported %d snapshots, skipped %d duplicates\n", p, imported, skipped)
+	}
+	return nil
+}
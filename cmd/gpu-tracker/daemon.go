@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"gpuwatch/internal/agent"
+	"gpuwatch/internal/api"
+	"gpuwatch/internal/detect"
+	"gpuwatch/internal/exporter"
+	"gpuwatch/internal/fleet"
+	"gpuwatch/internal/sampler"
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/stream"
+	"gpuwatch/internal/types"
+	"gpuwatch/internal/web"
+)
+
+// runDaemon samples every -interval (this machine, or the -hosts over SSH)
+// until SIGINT/SIGTERM, alerting on each sample, saving it with -continuous,
+// exposing it, the history API and the web dashboard with -serve, writing it
+// to -textfile, pushing it to the -agent aggregator and writing it to every
+// -sink and -otlp.
+func runDaemon(dbPath string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var db store.Store
+	var writer *store.Writer
+	if *continuousMode {
+		var err error
+		if db, err = openStore(dbPath); err != nil {
+			return fmt.Errorf("open db: %v", err)
+		}
+		defer db.Close()
+		writer = store.NewWriter(db, store.WriterOptions{})
+	} else if *serveAddr != "" {
+		// Without -continuous the API serves a history recorded by another
+		// process, if there is one.
+		var err error
+		if db, err = store.ConnectReadOnly(dbPath); err != nil {
+			log.Printf("History API disabled: %v", err)
+			db = nil
+		} else {
+			defer db.Close()
+		}
+	}
+
+	hosts, err := newHostPoller()
+	if err != nil {
+		return err
+	}
+	var src exporter.Source
+	var poller *sampler.Poller
+	if hosts != nil {
+		src = hosts
+	} else {
+		poller = sampler.NewPoller(time.Duration(sampleInterval))
+		src = poller
+	}
+	var srv *http.Server
+	var hub *stream.Hub
+	if *serveAddr != "" {
+		hub = stream.NewHub(0)
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /api/v1/stream", hub.ServeSSE)
+		mux.HandleFunc("GET /api/v1/ws", hub.ServeWS)
+		mux.Handle("/metrics", exporter.Handler(src))
+		a := api.New(db, src, loc)
+		a.Fleet = fleet.Config{MaxTemp: *maxTemp, MaxMem: *maxMem}
+		a.MaxGap = maxGap()
+		mux.Handle("/api/", a)
+		mux.Handle("/", web.Handler(web.Config{Refresh: time.Duration(sampleInterval), MaxTemp: *maxTemp, MaxMem: *maxMem}))
+		srv = &http.Server{Addr: *serveAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second,
+			// Streams end on SIGINT/SIGTERM instead of holding up Shutdown.
+			BaseContext: func(net.Listener) context.Context { return ctx }}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("serve: %v", err)
+				stop()
+			}
+		}()
+		fmt.Printf("Serving the dashboard at http://%s/, metrics at /metrics and the API at /api/v1/\n", *serveAddr)
+	}
+
+	if *textfileDir != "" {
+		if st, err := os.Stat(*textfileDir); err != nil || !st.IsDir() {
+			return fmt.Errorf("-textfile: %s is not a directory", *textfileDir)
+		}
+		fmt.Printf("Writing metrics to %s\n", filepath.Join(*textfileDir, exporter.TextfileName))
+	}
+
+	if (len(sinkSpecs) > 0 || *otlpFlag != "") && !*continuousMode {
+		return errors.New("-sink and -otlp need -continuous or the TUI")
+	}
+	sinks, err := openSinks()
+	if err != nil {
+		return err
+	}
+	defer closeSinks(sinks)
+	for _, b := range sinks {
+		fmt.Printf("Writing samples to %s\n", b.Name)
+	}
+
+	var pusher *agent.Pusher
+	if *agentURL != "" {
+		dir, err := spoolDir(dbPath)
+		if err != nil {
+			return err
+		}
+		if pusher, err = agent.NewPusher(agent.Options{URL: *agentURL, Token: *tokenFlag, SpoolDir: dir}); err != nil {
+			return err
+		}
+		fmt.Printf("Pushing samples to %s (spool: %s)\n", *agentURL, dir)
+	}
+
+	if hosts != nil {
+		fmt.Printf("Sampling %s over SSH\n", *hostsFlag)
+	}
+	if writer != nil {
+		fmt.Printf("Continuous mode: sampling every %s (Ctrl+C to stop)\n", time.Duration(sampleInterval))
+	} else {
+		fmt.Printf("Sampling every %s (Ctrl+C to stop)\n", time.Duration(sampleInterval))
+	}
+
+	idle := detect.NewIdleDetector(idleConfig())
+	leaks := detect.NewLeakDetector(leakConfig())
+	var lastErrors, lastPushErrors uint64
+	lastSinkErrors := make([]uint64, len(sinks))
+	handle := func(snap types.Snapshot, err error) {
+		if *textfileDir != "" {
+			// Written on errors too, so the health counters show a failing sampler.
+			h := src.Health()
+			if err := exporter.WriteTextfile(*textfileDir, exporter.Snapshots(src), &h); err != nil {
+				log.Printf("Textfile error: %v", err)
+			}
+		}
+		if err != nil {
+			log.Printf("Sample error: %v", err)
+			return
+		}
+		if hub != nil {
+			hub.Publish(snap)
+		}
+		checkAlerts(snap, *maxTemp, *maxMem)
+		checkIdle(idle, snap)
+		checkLeaks(leaks, snap)
+		if pusher != nil {
+			pusher.Enqueue(snap)
+			st := pusher.Stats()
+			if st.Errors > lastPushErrors {
+				log.Printf("Push error: %v (%d samples spooled)", st.LastErr, st.Spooled)
+				lastPushErrors = st.Errors
+			}
+		}
+		for i, b := range sinks {
+			b.Enqueue(snap)
+			if st := b.Stats(); st.Errors > lastSinkErrors[i] {
+				log.Printf("Sink %s error: %v (%d samples pending)", b.Name, st.LastErr, st.Pending)
+				lastSinkErrors[i] = st.Errors
+			}
+		}
+		if writer == nil {
+			return
+		}
+		if err := writer.Enqueue(snap); err != nil {
+			log.Printf("Save error: %v", err)
+			return
+		}
+		st := writer.Stats()
+		if st.Errors > lastErrors {
+			log.Printf("Save error: %v", st.LastErr)
+			lastErrors = st.Errors
+		}
+		of := ""
+		if hosts != nil {
+			of = " of " + snap.Host.Hostname
+		}
+		fmt.Printf("[%s] Queued snapshot%s (queue %d/%d, written %d, blocked %d)\n",
+			snap.TS.In(loc).Format("15:04:05.000"), of, st.Queued, st.Capacity, st.Written, st.Blocked)
+	}
+	if hosts != nil {
+		hosts.Run(ctx, func(r sampler.HostResult) { handle(r.Snap, r.Err) })
+	} else {
+		poller.Run(ctx, handle)
+	}
+
+	if srv != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}
+	if pusher != nil {
+		if err := pusher.Close(); err != nil {
+			log.Printf("Push error: %v (%d samples left in spool)", err, pusher.Stats().Spooled)
+		}
+	}
+	if writer != nil {
+		fmt.Println("Stopping: flushing pending snapshots...")
+		if err := writer.Close(); err != nil {
+			log.Printf("Save error: %v", err)
+		}
+	}
+	return nil
+}
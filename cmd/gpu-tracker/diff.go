@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gpuwatch/internal/diff"
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// resolveSnapshot loads a snapshot by numeric ID, or the last one of host
+// ("" = any) taken at or before a time in any -from format.
+func resolveSnapshot(db store.Store, arg, host string) (types.Snapshot, error) {
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		s, err := db.LoadSnapshot(id)
+		if err != nil {
+			return types.Snapshot{}, fmt.Errorf("snapshot #%d: %w", id, err)
+		}
+		return s, nil
+	}
+	t, err := parseTimeFlag(arg, time.Time{})
+	if err != nil {
+		return types.Snapshot{}, err
+	}
+	s, err := db.LoadAt(t, host)
+	if err != nil {
+		return types.Snapshot{}, fmt.Errorf("no snapshot at or before %s: %w", arg, err)
+	}
+	return s, nil
+}
+
+func runDiff(db store.Store, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("-diff needs two snapshots (ID or time) as arguments")
+	}
+	a, err := resolveSnapshot(db, args[0], *hostFlag)
+	if err != nil {
+		return err
+	}
+	// Without -host, a time resolves on the first snapshot's host rather
+	// than to whichever host was sampled last.
+	host := *hostFlag
+	if host == "" {
+		host = a.Host.Hostname
+	}
+	b, err := resolveSnapshot(db, args[1], host)
+	if err != nil {
+		return err
+	}
+	d, err := diff.Compare(a, b)
+	if err != nil {
+		return err
+	}
+	out, err := openOutput(*exportFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return writeDiff(out, d, *formatFlag)
+}
+
+func writeDiff(w io.Writer, d diff.Diff, format string) error {
+	switch format {
+	case "table", "text":
+		fmt.Fprintf(w, "Snapshot #%d (%s) → #%d (%s), %s apart", d.FromID, d.From.In(loc).Format("2006-01-02 15:04:05"),
+			d.ToID, d.To.In(loc).Format("2006-01-02 15:04:05"), d.Elapsed.Round(time.Second))
+		if d.Host != "" {
+			fmt.Fprintf(w, " on %s", d.Host)
+		}
+		fmt.Fprint(w, "\n\nGPUs\n")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "GPU\tname\tutil %%\tmem MB\ttemp °C\tpower W\t\n")
+		for _, g := range d.GPUs {
+			name := g.Name
+			if g.Status != "" {
+				name += " (" + g.Status + ")"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t\n", g.Index, name,
+				fmtDelta(g.UtilGPU), fmtDelta(g.MemMB), fmtDelta(g.TempC), fmtDelta(g.PowerW))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		for _, sec := range []struct {
+			title string
+			procs []diff.ProcChange
+		}{{"Started", d.Started}, {"Ended", d.Ended}, {"Memory changed", d.Changed}} {
+			if len(sec.procs) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\n%s processes\n", sec.title)
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(tw, "PID\tuser\tprocess\tGPU\tmem MB\t\n")
+			for _, p := range sec.procs {
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t\n", p.PID, p.User, p.ProcessName, p.GPUIndex, fmtDelta(p.MemMB))
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprint(w, "\nUsers\n")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "user\tprocesses\tmem MB\t\n")
+		for _, u := range d.Users {
+			name := u.User
+			if u.Status != "" {
+				name += " (" + u.Status + ")"
+			}
+			fmt.Fprintf(tw, "%s\t%d → %d\t%s\t\n", name, u.Procs[0], u.Procs[1], fmtDelta(u.MemMB))
+		}
+		return tw.Flush()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	default:
+		return fmt.Errorf("unknown format: %s (supported: table, json)", format)
+	}
+}
+
+func fmtDelta(d diff.Delta) string {
+	return fmt.Sprintf("%.0f → %.0f (%+.0f)", d.Before, d.After, d.Change)
+}
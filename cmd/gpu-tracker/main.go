@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"flag"
@@ -8,9 +10,16 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"gpuwatch/internal/accounting"
+	"gpuwatch/internal/detect"
+	"gpuwatch/internal/energy"
+	"gpuwatch/internal/export"
 	"gpuwatch/internal/sampler"
+	"gpuwatch/internal/sink"
 	"gpuwatch/internal/store"
 	"gpuwatch/internal/tui"
 	"gpuwatch/internal/types"
@@ -19,20 +28,107 @@ import (
 )
 
 var (
-	sampleIntervalFlag = flag.Int("interval", 5, "Sampling interval in seconds (default: 5)")
-	exportFormat       = flag.String("export", "", "Export current snapshot to file (formats: json, csv)")
-	exportFile         = flag.String("output", "", "Output file for export (default: stdout)")
-	dbPathFlag         = flag.String("db", "", "Custom database path (default: ~/.local/share/gpuwatch/gpuwatch.db)")
-	oneShotMode        = flag.Bool("once", false, "Sample once and exit (no TUI)")
-	continuousMode     = flag.Bool("continuous", false, "Continuously sample and save without TUI")
-	showVersion        = flag.Bool("version", false, "Show version information")
-	maxTemp            = flag.Float64("max-temp", 90.0, "Alert threshold for GPU temperature (°C)")
-	maxMem             = flag.Float64("max-mem", 95.0, "Alert threshold for memory usage (%)")
-	listUsers          = flag.Bool("list-users", false, "List all users using GPUs and exit")
+	sampleInterval = intervalFlag(5 * time.Second)
+	exportFormat   = flag.String("export", "", "Export current snapshot (formats: json, csv), or history when -from/-to is set (formats: csv, ndjson, parquet)")
+	exportFile     = flag.String("output", "", "Output file for export (default: stdout)")
+	dbPathFlag     = flag.String("db", "", "Custom database path or postgres:// DSN (default: ~/.local/share/gpuwatch/gpuwatch.db)")
+	oneShotMode    = flag.Bool("once", false, "Sample once and exit (no TUI)")
+	continuousMode = flag.Bool("continuous", false, "Continuously sample and save without TUI")
+	showVersion    = flag.Bool("version", false, "Show version information")
+	maxTemp        = flag.Float64("max-temp", 90.0, "Alert threshold for GPU temperature (°C)")
+	maxMem         = flag.Float64("max-mem", 95.0, "Alert threshold for memory usage (%)")
+	listUsers      = flag.Bool("list-users", false, "List all users using GPUs and exit")
+	hostFlag       = flag.String("host", "", "Only use snapshots from this hostname in history and reports (default: all hosts)")
+	labelsFlag     = flag.String("labels", "", "Host labels attached to every sample, e.g. rack=a3,site=lab")
+	mergeMode      = flag.Bool("merge", false, "Import snapshots from the gpuwatch DB files given as arguments into -db and exit")
+	importFormat   = flag.String("import", "", "Import recordings of other tools given as arguments into -db and exit (formats: nvidia-smi, dcgm)")
+	diffMode       = flag.Bool("diff", false, "Compare the two snapshots given as arguments (ID or time) and exit; -format table or json")
+	backupFlag     = flag.String("backup", "", "Write a consistent copy of the SQLite database to this file and exit (safe while -continuous runs)")
+	archiveFlag    = flag.String("archive", "", "Export snapshots (all, or -from/-to/-host) to a portable .jsonl.gz archive and exit")
+	restoreFlag    = flag.String("restore", "", "Validate and merge an archive or SQLite backup into -db and exit")
+	reportKind     = flag.String("report", "", "Generate a report from history and exit (reports: accounting, chargeback, energy, idle)")
+	fromFlag       = flag.String("from", "", "Report range start (YYYY-MM-DD, \"YYYY-MM-DD HH:MM\" or RFC3339; default: 30 days ago)")
+	toFlag         = flag.String("to", "", "Report range end, exclusive (default: now)")
+	groupByFlag    = flag.String("by", "user", "Group accounting by: user, group, gpu, host")
+	formatFlag     = flag.String("format", "table", "Report output format (formats: table, csv, json; chargeback also markdown)")
+	monthFlag      = flag.String("month", "", "Chargeback month YYYY-MM (default: last complete month)")
+	ratesFlag      = flag.String("rates", "", "Chargeback config file with GPU rates, energy price and group mappings (JSON)")
+	idleMem        = flag.Float64("idle-mem", detect.DefaultIdleConfig.MinMemMB, "Idle-allocation alert: minimum memory held by a process (MB)")
+	idleUtil       = flag.Float64("idle-util", detect.DefaultIdleConfig.MaxUtil, "Idle-allocation alert: GPU utilization at or below this counts as idle (%)")
+	idleFor        = flag.Duration("idle-for", detect.DefaultIdleConfig.MinDuration, "Idle-allocation alert: how long memory must be held while idle")
+	leakWindow     = flag.Duration("leak-window", detect.DefaultLeakConfig.Window, "Memory growth alert: sliding window for per-process trend analysis")
+	leakRate       = flag.Float64("leak-rate", detect.DefaultLeakConfig.MinRate, "Memory growth alert: sustained growth rate that counts as a leak (MB/h)")
+	gpuFlag        = flag.Int("gpu", -1, "History export: only this GPU index (default: all)")
+	userFlag       = flag.String("user", "", "History export: only processes of this user (default: all)")
+	serveAddr      = flag.String("serve", "", "Serve the web dashboard, Prometheus metrics and the JSON API at this address, e.g. :9400 (combine with -continuous to also record)")
+	textfileDir    = flag.String("textfile", "", "Write metrics to gpuwatch.prom in this node_exporter textfile-collector directory after every sample")
+	agentURL       = flag.String("agent", "", "Push every sample to the aggregator at this URL, e.g. http://central:9400 (spools to disk while it is down)")
+	spoolFlag      = flag.String("spool", "", "Directory for samples waiting to be pushed by -agent (default: spool next to the database)")
+	aggregateAddr  = flag.String("aggregate", "", "Run an aggregator at this address: store samples pushed by agents and serve the fleet dashboard and API")
+	tokenFlag      = flag.String("token", "", "Shared secret between -agent and -aggregate (default: $GPUWATCH_TOKEN)")
+	otlpFlag       = flag.String("otlp", "", "Export metrics over OTLP/HTTP to this endpoint, e.g. http://collector:4318, with -continuous or the TUI")
+	otlpHeaders    = flag.String("otlp-headers", "", "OTLP request headers k=v,... (default: $OTEL_EXPORTER_OTLP_HEADERS)")
+	otlpProtocol   = flag.String("otlp-protocol", "", "OTLP encoding: http/protobuf or http/json (default: $OTEL_EXPORTER_OTLP_PROTOCOL, else http/protobuf)")
+	influxToken    = flag.String("influx-token", "", "API token for influx+http(s) sinks (default: $INFLUX_TOKEN)")
+	sinkBatch      = flag.Int("sink-batch", sink.DefaultBatchSize, "Samples per write to each -sink and -otlp")
+	sinkFlush      = flag.Duration("sink-flush", sink.DefaultFlushInterval, "Longest wait before a -sink or -otlp writes a partial batch")
+	sinkMaxPending = flag.Int("sink-max-pending", sink.DefaultMaxPending, "Samples each -sink and -otlp keeps in memory while it is down, oldest dropped first")
+	sinkTimeout    = flag.Duration("sink-timeout", sink.DefaultTimeout, "Time limit for each write to a -sink or -otlp")
+	readOnlyFlag   = flag.Bool("readonly", false, "Open the database read-only: the TUI follows snapshots recorded by another process instead of sampling")
+	hostsFlag      = flag.String("hosts", "", "Sample these hosts over SSH instead of this machine: a comma-separated list, or a file with one \"host [k=v ...]\" per line")
+	sshTimeout     = flag.Duration("ssh-timeout", 10*time.Second, "-hosts: time limit for each host's sample, including the SSH connection")
+	fleetFlag      = flag.Bool("fleet", false, "Start the TUI in the fleet overview of every host in the database (F toggles it)")
+	tzFlag         = flag.String("tz", "", "Time zone for history, reports and -from/-to, e.g. Europe/Berlin or UTC (default: local)")
+	maxGapFlag     = flag.Duration("max-gap", 0, "Longest time one sample is accounted for in reports and idle-allocation tracking, e.g. while the recorder was stopped (default: twice -interval, at least 5m)")
+	carbonFlag     = flag.String("carbon", "", "Carbon intensity in gCO2e/kWh, or a time-of-day table file with HH:MM,grams lines")
 )
 
 const version = "1.1.0"
 
+// loc is the -tz zone. Snapshots are stored as zone-free Unix times and only
+// converted to loc for display, day boundaries and parsing -from/-to.
+var loc = time.Local
+
+// sinkSpecs are the -sink URLs.
+var sinkSpecs listFlag
+
+func init() {
+	flag.Var(&sampleInterval, "interval", "Sampling interval: seconds (5) or a duration (500ms, 1m)")
+	flag.Var(&sinkSpecs, "sink", "With -continuous or the TUI, also write every sample to this sink (repeatable): influx+http://host:8086/api/v2/write?org=O&bucket=B, influx+udp://host:8089 or graphite://host:2003")
+}
+
+// listFlag collects the values of a repeated flag.
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, " ") }
+
+func (f *listFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// intervalFlag accepts a bare number of seconds, as older versions did, or a
+// Go duration for sub-second sampling.
+type intervalFlag time.Duration
+
+func (f *intervalFlag) String() string { return time.Duration(*f).String() }
+
+func (f *intervalFlag) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		secs, perr := strconv.ParseFloat(s, 64)
+		if perr != nil {
+			return err
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	*f = intervalFlag(d)
+	return nil
+}
+
 func ensureDataDir() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -76,7 +172,7 @@ func exportToCSV(snap types.Snapshot, path string) error {
 		return err
 	}
 
-	ts := snap.TS.Format(time.RFC3339)
+	ts := snap.TS.In(loc).Format(time.RFC3339Nano)
 	for _, gpu := range snap.GPUs {
 		// Find processes for this GPU
 		hasProc := false
@@ -148,9 +244,153 @@ func checkAlerts(snap types.Snapshot, maxTemp, maxMem float64) {
 	}
 }
 
+// maxGap is -max-gap, or the default for the -interval samples are
+// recorded at.
+func maxGap() time.Duration {
+	if *maxGapFlag > 0 {
+		return *maxGapFlag
+	}
+	return accounting.MaxGapFor(time.Duration(sampleInterval))
+}
+
+// idleConfig builds the idle-allocation detector settings from flags. A gap
+// longer than maxGap restarts tracking, so slow -interval sampling still
+// accumulates idle time.
+func idleConfig() detect.IdleConfig {
+	cfg := detect.DefaultIdleConfig
+	cfg.MinMemMB = *idleMem
+	cfg.MaxUtil = *idleUtil
+	cfg.MinDuration = *idleFor
+	cfg.MaxGap = maxGap()
+	return cfg
+}
+
+// checkIdle prints an alert the first time a process is flagged as holding an idle GPU.
+func checkIdle(det *detect.IdleDetector, snap types.Snapshot) {
+	for _, a := range det.Observe(snap) {
+		if !a.New {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  ALERT: %s (PID %d, %s) holds %.0f MB on idle GPU %d for %s\n",
+			a.User, a.PID, a.ProcessName, a.MemMB, a.GPUIndex, a.Duration().Round(time.Second))
+	}
+}
+
+// leakConfig builds the memory growth detector settings from flags.
+func leakConfig() detect.LeakConfig {
+	cfg := detect.DefaultLeakConfig
+	cfg.Window = *leakWindow
+	cfg.MinRate = *leakRate
+	return cfg
+}
+
+// checkLeaks prints an alert when a process starts growing its GPU memory steadily.
+func checkLeaks(det *detect.LeakDetector, snap types.Snapshot) {
+	for _, g := range det.Observe(snap) {
+		if !g.New {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  ALERT: %s (PID %d, %s) GPU %d memory growing %.0f MB/h (now %.0f MB), OOM in ~%s\n",
+			g.User, g.PID, g.ProcessName, g.GPUIndex, g.RateMBPerH, g.MemMB, g.TimeToOOM.Round(time.Minute))
+	}
+}
+
+// parseLabels parses k=v pairs separated by commas.
+func parseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid label %q (want key=value)", kv)
+		}
+		labels[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return labels, nil
+}
+
+// openStore connects to the -db history, read-only with -readonly.
+func openStore(dsn string) (store.Store, error) {
+	if *readOnlyFlag {
+		return store.ConnectReadOnly(dsn)
+	}
+	return store.Connect(dsn)
+}
+
+// exportHistory streams the -from/-to range, filtered by -host, -gpu and
+// -user, to -output.
+func exportHistory(db store.Store, format string) error {
+	from, to, err := reportRange()
+	if err != nil {
+		return err
+	}
+	out, err := openOutput(*exportFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	bw := bufio.NewWriter(out)
+	w, err := export.NewWriter(format, bw)
+	if err != nil {
+		return err
+	}
+	n, err := export.Range(db, from, to, export.Filter{Host: *hostFlag, GPU: *gpuFlag, User: *userFlag}, loc, maxGap(), w)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	if *exportFile != "" {
+		fmt.Printf("Exported %d rows to %s\n", n, *exportFile)
+	}
+	return nil
+}
+
+// mergeFiles imports other gpuwatch databases into db. Snapshots recorded
+// before host identity existed are attributed to the file name.
+func mergeFiles(db store.Store, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("-merge needs one or more database files as arguments")
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return err
+		}
+		src, err := store.OpenCopy(p) // leaves older databases unmigrated
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		fallback := types.Host{Hostname: strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))}
+		imported, skipped, err := store.Merge(db, src, fallback)
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		fmt.Printf("%s: imported %d snapshots, skipped %d duplicates\n", p, imported, skipped)
+	}
+	return nil
+}
+
 func main() {
 	flag.Parse()
 
+	labels, err := parseLabels(*labelsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sampler.SetHostLabels(labels)
+	if *tzFlag != "" {
+		if loc, err = time.LoadLocation(*tzFlag); err != nil {
+			log.Fatalf("invalid -tz: %v", err)
+		}
+	}
+
 	if *showVersion {
 		fmt.Printf("gpuwatch version %s\n", version)
 		return
@@ -168,8 +408,127 @@ func main() {
 		dbPath = filepath.Join(dataDir, "gpuwatch.db")
 	}
 
+	if *readOnlyFlag && (*mergeMode || *restoreFlag != "" || *importFormat != "" || *continuousMode || *aggregateAddr != "" || *hostsFlag != "") {
+		log.Fatal("-readonly cannot be combined with -merge, -restore, -import, -continuous, -aggregate or -hosts")
+	}
+	if *tokenFlag == "" {
+		*tokenFlag = os.Getenv("GPUWATCH_TOKEN")
+	}
+	sink.OTLPScope.Version = version
+	if *influxToken == "" {
+		*influxToken = os.Getenv("INFLUX_TOKEN")
+	}
+	if *readOnlyFlag && (len(sinkSpecs) > 0 || *otlpFlag != "") {
+		log.Fatal("-readonly cannot be combined with -sink or -otlp")
+	}
+
+	// Merge mode: import other databases and exit
+	if *mergeMode {
+		db, err := openStore(dbPath)
+		if err != nil {
+			log.Fatalf("open db: %v", err)
+		}
+		defer db.Close()
+		if err := mergeFiles(db, flag.Args()); err != nil {
+			log.Fatalf("Merge failed: %v", err)
+		}
+		return
+	}
+
+	// Backup, archive and restore: copy history and exit
+	if *backupFlag != "" || *archiveFlag != "" || *restoreFlag != "" {
+		db, err := openStore(dbPath)
+		if err != nil {
+			log.Fatalf("open db: %v", err)
+		}
+		defer db.Close()
+		switch {
+		case *backupFlag != "":
+			err = backupDB(db, *backupFlag)
+		case *archiveFlag != "":
+			err = archiveDB(db, *archiveFlag)
+		default:
+			err = restoreFile(db, *restoreFlag)
+		}
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	// Import mode: convert recordings of other tools and exit
+	if *importFormat != "" {
+		db, err := openStore(dbPath)
+		if err != nil {
+			log.Fatalf("open db: %v", err)
+		}
+		defer db.Close()
+		if err := importFiles(db, *importFormat, flag.Args()); err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		return
+	}
+
+	// Diff mode: compare two stored snapshots and exit
+	if *diffMode {
+		db, err := openStore(dbPath)
+		if err != nil {
+			log.Fatalf("open db: %v", err)
+		}
+		defer db.Close()
+		if err := runDiff(db, flag.Args()); err != nil {
+			log.Fatalf("Diff failed: %v", err)
+		}
+		return
+	}
+
+	// Report mode: integrate stored history and exit
+	if *reportKind != "" {
+		db, err := openStore(dbPath)
+		if err != nil {
+			log.Fatalf("open db: %v", err)
+		}
+		defer db.Close()
+		if err := runReport(db, *reportKind); err != nil {
+			log.Fatalf("Report failed: %v", err)
+		}
+		return
+	}
+
+	// History export: stream stored snapshots in a range and exit
+	if *exportFormat != "" && (*fromFlag != "" || *toFlag != "") {
+		db, err := openStore(dbPath)
+		if err != nil {
+			log.Fatalf("open db: %v", err)
+		}
+		defer db.Close()
+		if err := exportHistory(db, *exportFormat); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		return
+	}
+
+	// Aggregator mode: store samples pushed by agents until interrupted
+	if *aggregateAddr != "" {
+		if err := runAggregator(dbPath); err != nil {
+			log.Fatalf("Aggregator failed: %v", err)
+		}
+		return
+	}
+
 	// One-shot mode: sample once and optionally export
 	if *oneShotMode || *listUsers || *exportFormat != "" {
+		if *hostsFlag != "" {
+			if *listUsers || *exportFormat != "" {
+				log.Fatal("-hosts cannot be combined with -list-users or -export")
+			}
+			hp, err := newHostPoller()
+			if err != nil {
+				log.Fatal(err)
+			}
+			sampleHostsOnce(hp)
+			return
+		}
 		snap, err := sampler.Sample()
 		if err != nil {
 			log.Fatalf("Failed to sample: %v", err)
@@ -199,7 +558,7 @@ func main() {
 		}
 
 		// Just print snapshot
-		fmt.Printf("Snapshot at %s\n", snap.TS.Format(time.RFC3339))
+		fmt.Printf("Snapshot at %s\n", snap.TS.In(loc).Format(time.RFC3339))
 		for _, gpu := range snap.GPUs {
 			fmt.Printf("GPU %d: %s - Util: %.1f%%, Mem: %.1f%%, Temp: %.1f°C\n",
 				gpu.Index, gpu.Name, gpu.UtilGPU, gpu.UtilMem, gpu.TempC)
@@ -207,51 +566,87 @@ func main() {
 		return
 	}
 
-	// Continuous mode: sample and save without TUI
-	if *continuousMode {
-		db, err := store.Open(dbPath)
-		if err != nil {
-			log.Fatalf("open db: %v", err)
-		}
-		defer db.Close()
-
-		fmt.Printf("Continuous mode: sampling every %d seconds (Ctrl+C to stop)\n", *sampleIntervalFlag)
-		ticker := time.NewTicker(time.Duration(*sampleIntervalFlag) * time.Second)
-		defer ticker.Stop()
-
-		for {
-			snap, err := sampler.Sample()
-			if err != nil {
-				log.Printf("Sample error: %v", err)
-				continue
-			}
-			checkAlerts(snap, *maxTemp, *maxMem)
-			id, err := db.SaveSnapshot(snap)
-			if err != nil {
-				log.Printf("Save error: %v", err)
-			} else {
-				fmt.Printf("[%s] Saved snapshot #%d\n", snap.TS.Format("15:04:05"), id)
-			}
-			<-ticker.C
+	// Continuous, serve, textfile and agent modes: sample on a fixed interval without TUI
+	if *continuousMode || *serveAddr != "" || *textfileDir != "" || *agentURL != "" {
+		if err := runDaemon(dbPath); err != nil {
+			log.Fatal(err)
 		}
+		return
 	}
 
 	// Normal TUI mode
-	db, err := store.Open(dbPath)
+	db, err := openStore(dbPath)
 	if err != nil {
 		log.Fatalf("open db: %v", err)
 	}
 	defer db.Close()
 
-	sampleInterval := time.Duration(*sampleIntervalFlag) * time.Second
+	carbon, err := energy.ParseIntensity(*carbonFlag)
+	if err != nil {
+		log.Fatalf("carbon: %v", err)
+	}
+
+	var writer *store.Writer
+	if !*readOnlyFlag {
+		writer = store.NewWriter(db, store.WriterOptions{})
+	}
+
+	sinks, err := openSinks()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// With -hosts the samples are recorded in the background and the TUI
+	// follows them from the store, starting with the fleet overview.
+	hosts, err := newHostPoller()
+	if err != nil {
+		log.Fatal(err)
+	}
+	stopHosts := func() {}
+	if hosts != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			hosts.Run(ctx, func(r sampler.HostResult) {
+				if r.Err == nil {
+					_ = writer.Enqueue(r.Snap) // errors show in the writer stats
+					for _, b := range sinks {
+						b.Enqueue(r.Snap)
+					}
+				}
+			})
+		}()
+		stopHosts = func() { cancel(); <-done }
+	}
+
 	m := tui.NewWithConfig(db, tui.Config{
-		SampleInterval: sampleInterval,
+		SampleInterval: time.Duration(sampleInterval),
 		MaxTemp:        *maxTemp,
 		MaxMem:         *maxMem,
+		Host:           *hostFlag,
+		Location:       loc,
+		Carbon:         carbon.In(loc),
+		MaxGap:         maxGap(),
+		Idle:           idleConfig(),
+		Leak:           leakConfig(),
+		Writer:         writer,
+		ReadOnly:       *readOnlyFlag || hosts != nil,
+		Fleet:          *fleetFlag || hosts != nil,
+		Hosts:          hosts,
+		Sinks:          sinks,
 	})
 	p := tea.NewProgram(m, tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
-		fmt.Println("error:", err)
+	_, runErr := p.Run()
+	stopHosts()
+	closeSinks(sinks)
+	if writer != nil {
+		if err := writer.Close(); err != nil {
+			log.Printf("Save error: %v", err)
+		}
+	}
+	if runErr != nil {
+		fmt.Println("error:", runErr)
 		os.Exit(1)
 	}
 }
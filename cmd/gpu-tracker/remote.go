@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	"gpuwatch/internal/sampler"
+)
+
+// parseHosts reads -hosts: a file with one "host [k=v ...]" per line (blank
+// lines and # comments are skipped), or else a comma-separated list of
+// hosts. Hosts are [user@]host or ~/.ssh/config aliases; labels add to the
+// -labels of every host.
+func parseHosts(s string, labels map[string]string) ([]sampler.Target, error) {
+	var lines []string
+	if st, err := os.Stat(s); err == nil && !st.IsDir() {
+		f, err := os.Open(s)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line, _, _ := strings.Cut(sc.Text(), "#")
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, h := range strings.Split(s, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				lines = append(lines, h)
+			}
+		}
+	}
+
+	var targets []sampler.Target
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		f := strings.Fields(line)
+		host, rest := f[0], strings.Join(f[1:], ",")
+		if seen[host] {
+			return nil, fmt.Errorf("-hosts: %s listed twice", host)
+		}
+		seen[host] = true
+		extra, err := parseLabels(rest)
+		if err != nil {
+			return nil, fmt.Errorf("-hosts: %s: %v", host, err)
+		}
+		var l map[string]string
+		if len(labels)+len(extra) > 0 {
+			l = maps.Clone(labels)
+			if l == nil {
+				l = make(map[string]string)
+			}
+			maps.Copy(l, extra)
+		}
+		targets = append(targets, sampler.Target{Name: host, Labels: l, Runner: sampler.SSH{Target: host}})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("-hosts: no hosts in %q", s)
+	}
+	return targets, nil
+}
+
+// newHostPoller returns a poller for -hosts, or nil without it.
+func newHostPoller() (*sampler.HostPoller, error) {
+	if *hostsFlag == "" {
+		return nil, nil
+	}
+	labels, err := parseLabels(*labelsFlag)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := parseHosts(*hostsFlag, labels)
+	if err != nil {
+		return nil, err
+	}
+	return sampler.NewHostPoller(targets, time.Duration(sampleInterval), *sshTimeout), nil
+}
+
+// sampleHostsOnce prints one sample of every -hosts host, like -once does
+// locally.
+func sampleHostsOnce(hp *sampler.HostPoller) {
+	failed := 0
+	for _, r := range hp.Collect(context.Background()) {
+		if r.Err != nil {
+			log.Printf("Failed to sample %v", r.Err)
+			failed++
+			continue
+		}
+		snap := r.Snap
+		checkAlerts(snap, *maxTemp, *maxMem)
+		fmt.Printf("Snapshot of %s at %s (%s)\n", snap.Host.Hostname, snap.TS.In(loc).Format(time.RFC3339),
+			snap.Duration.Round(time.Millisecond))
+		for _, gpu := range snap.GPUs {
+			fmt.Printf("GPU %d: %s - Util: %.1f%%, Mem: %.1f%%, Temp: %.1f°C\n",
+				gpu.Index, gpu.Name, gpu.UtilGPU, gpu.UtilMem, gpu.TempC)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
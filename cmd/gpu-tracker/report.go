@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gpuwatch/internal/accounting"
+	"gpuwatch/internal/chargeback"
+	"gpuwatch/internal/detect"
+	"gpuwatch/internal/energy"
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/util"
+)
+
+// parseTimeFlag accepts a date, a date with minutes or an RFC3339 timestamp;
+// times without an offset are read in the -tz zone.
+func parseTimeFlag(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (use YYYY-MM-DD, \"YYYY-MM-DD HH:MM\" or RFC3339)", s)
+	}
+	return t.In(loc), nil
+}
+
+// reportRange resolves -from/-to; the default is the last 30 days.
+func reportRange() (time.Time, time.Time, error) {
+	to, err := parseTimeFlag(*toFlag, time.Now().In(loc))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	from, err := parseTimeFlag(*fromFlag, to.AddDate(0, 0, -30))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("-from must be before -to")
+	}
+	return from, to, nil
+}
+
+// openOutput returns the -output file or stdout.
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func runReport(db store.Store, kind string) error {
+	from, to, err := reportRange()
+	if err != nil {
+		return err
+	}
+	out, err := openOutput(*exportFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	switch kind {
+	case "accounting":
+		opts := accounting.Options{GroupBy: *groupByFlag, Host: *hostFlag, MaxGap: maxGap()}
+		switch opts.GroupBy {
+		case accounting.ByUser, accounting.ByGPU, accounting.ByHost:
+		case accounting.ByGroup:
+			opts.UserGroups = util.BuildUserGroupMap()
+		default:
+			return fmt.Errorf("unknown -by value: %s (supported: user, group, gpu, host)", opts.GroupBy)
+		}
+		rep, err := accounting.Compute(db, from, to, opts)
+		if err != nil {
+			return err
+		}
+		return writeAccounting(out, rep, *formatFlag)
+	case "chargeback":
+		if *ratesFlag == "" {
+			return fmt.Errorf("chargeback requires -rates <config.json>")
+		}
+		cfg, err := chargeback.LoadConfig(*ratesFlag)
+		if err != nil {
+			return err
+		}
+		month, err := parseMonthFlag(*monthFlag)
+		if err != nil {
+			return err
+		}
+		invoices, err := chargeback.Generate(db, cfg, month, maxGap())
+		if err != nil {
+			return err
+		}
+		return writeInvoices(out, invoices, *formatFlag)
+	case "energy":
+		carbon, err := energy.ParseIntensity(*carbonFlag)
+		if err != nil {
+			return err
+		}
+		rep, err := energy.Compute(db, from, to, *hostFlag, carbon.In(loc), maxGap())
+		if err != nil {
+			return err
+		}
+		return writeEnergy(out, rep, *formatFlag)
+	case "idle":
+		eps, err := detect.FindIdle(db, from, to, *hostFlag, idleConfig())
+		if err != nil {
+			return err
+		}
+		for i := range eps {
+			eps[i].Since, eps[i].Last = eps[i].Since.In(loc), eps[i].Last.In(loc)
+		}
+		return writeIdle(out, eps, *formatFlag)
+	default:
+		return fmt.Errorf("unknown report: %s (supported: accounting, chargeback, energy, idle)", kind)
+	}
+}
+
+func writeIdle(w io.Writer, eps []detect.IdleEpisode, format string) error {
+	switch format {
+	case "table":
+		if len(eps) == 0 {
+			fmt.Fprintln(w, "No idle allocations found.")
+			return nil
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "host\tuser\tPID\tprocess\tGPU\tpeak MB\tfrom\tto\tduration\t\n")
+		for _, e := range eps {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%.0f\t%s\t%s\t%s\t\n", e.Host, e.User, e.PID, e.ProcessName, e.GPUIndex, e.PeakMemMB,
+				e.Since.Format("2006-01-02 15:04"), e.Last.Format("2006-01-02 15:04"), e.Duration().Round(time.Minute))
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"Host", "User", "PID", "Process", "GPU Index", "GPU UUID", "Peak Mem MB", "From", "To", "Duration Seconds"}); err != nil {
+			return err
+		}
+		for _, e := range eps {
+			if err := cw.Write([]string{
+				e.Host, e.User, fmt.Sprintf("%d", e.PID), e.ProcessName, fmt.Sprintf("%d", e.GPUIndex), e.GPUUUID,
+				fmt.Sprintf("%.1f", e.PeakMemMB), e.Since.Format(time.RFC3339), e.Last.Format(time.RFC3339),
+				fmt.Sprintf("%.0f", e.Duration().Seconds()),
+			}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(eps)
+	default:
+		return fmt.Errorf("unknown format: %s (supported: table, csv, json)", format)
+	}
+}
+
+func writeEnergy(w io.Writer, rep energy.Report, format string) error {
+	type scoped struct {
+		scope string
+		rows  []energy.Row
+	}
+	sections := []scoped{
+		{"host", rep.Hosts},
+		{"gpu", rep.GPUs},
+		{"user", rep.Users},
+		{"unattributed", []energy.Row{rep.Unattributed}},
+	}
+	switch format {
+	case "table":
+		fmt.Fprintf(w, "Energy from %s to %s (%d samples)\n\n",
+			rep.From.Format("2006-01-02 15:04"), rep.To.Format("2006-01-02 15:04"), rep.Samples)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "scope\tkey\tEnergy kWh\tCO2e kg\t\n")
+		for _, sec := range sections {
+			for _, r := range sec.rows {
+				fmt.Fprintf(tw, "%s\t%s\t%.3f\t%.3f\t\n", sec.scope, r.Key, r.EnergyKWh, r.CarbonKg)
+			}
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"Scope", "Key", "Energy kWh", "CO2e kg"}); err != nil {
+			return err
+		}
+		for _, sec := range sections {
+			for _, r := range sec.rows {
+				if err := cw.Write([]string{sec.scope, r.Key, fmt.Sprintf("%.4f", r.EnergyKWh), fmt.Sprintf("%.4f", r.CarbonKg)}); err != nil {
+					return err
+				}
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	default:
+		return fmt.Errorf("unknown format: %s (supported: table, csv, json)", format)
+	}
+}
+
+// parseMonthFlag parses YYYY-MM; the default is the last complete month.
+func parseMonthFlag(s string) (time.Time, error) {
+	if s == "" {
+		now := time.Now().In(loc)
+		return time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, loc), nil
+	}
+	t, err := time.ParseInLocation("2006-01", s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid month %q (use YYYY-MM)", s)
+	}
+	return t, nil
+}
+
+func writeInvoices(w io.Writer, invoices []chargeback.Invoice, format string) error {
+	switch format {
+	case "table", "markdown", "md":
+		for _, inv := range invoices {
+			fmt.Fprintf(w, "## Invoice %s — %s\n\n", inv.Period, inv.Group)
+			if inv.Project != "" {
+				fmt.Fprintf(w, "Project: %s  \n", inv.Project)
+			}
+			fmt.Fprintf(w, "Period: %s to %s\n\n", inv.From.Format("2006-01-02"), inv.To.Format("2006-01-02"))
+			fmt.Fprintln(w, "| User | Item | Quantity | Rate | Amount |")
+			fmt.Fprintln(w, "|------|------|---------:|-----:|-------:|")
+			for _, l := range inv.Lines {
+				fmt.Fprintf(w, "| %s | %s | %.2f %s | %.4f | %.2f |\n", l.User, l.Description, l.Quantity, l.Unit, l.Rate, l.Amount)
+			}
+			fmt.Fprintf(w, "| **Total** | | | | **%.2f %s** |\n\n", inv.Total, inv.Currency)
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"Period", "Group", "Project", "User", "Item", "Quantity", "Unit", "Rate", "Amount", "Currency"}); err != nil {
+			return err
+		}
+		for _, inv := range invoices {
+			for _, l := range inv.Lines {
+				if err := cw.Write([]string{
+					inv.Period, inv.Group, inv.Project, l.User, l.Description,
+					fmt.Sprintf("%.4f", l.Quantity), l.Unit,
+					fmt.Sprintf("%.4f", l.Rate), fmt.Sprintf("%.2f", l.Amount), inv.Currency,
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(invoices)
+	default:
+		return fmt.Errorf("unknown format: %s (supported: markdown, csv, json)", format)
+	}
+}
+
+func writeAccounting(w io.Writer, rep accounting.Report, format string) error {
+	switch format {
+	case "table":
+		fmt.Fprintf(w, "Accounting by %s from %s to %s (%d samples)\n\n",
+			rep.GroupBy, rep.From.Format("2006-01-02 15:04"), rep.To.Format("2006-01-02 15:04"), rep.Samples)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\tGPU-hours\tMem GB-hours\tEnergy kWh\t\n", rep.GroupBy)
+		for _, u := range append(rep.Rows, rep.Total()) {
+			fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%.2f\t\n", u.Key, u.GPUHours, u.MemGBHours, u.EnergyKWh)
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{rep.GroupBy, "GPU Hours", "Mem GB Hours", "Energy kWh"}); err != nil {
+			return err
+		}
+		for _, u := range rep.Rows {
+			if err := cw.Write([]string{u.Key, fmt.Sprintf("%.4f", u.GPUHours), fmt.Sprintf("%.4f", u.MemGBHours), fmt.Sprintf("%.4f", u.EnergyKWh)}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	default:
+		return fmt.Errorf("unknown format: %s (supported: table, csv, json)", format)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"gpuwatch/internal/sink"
+)
+
+// openSinks opens every -sink and the -otlp endpoint, each behind its own
+// Batcher named by its redacted URL and configured by the -sink-* flags.
+func openSinks() ([]*sink.Batcher, error) {
+	specs := append([]string(nil), sinkSpecs...)
+	if *otlpFlag != "" {
+		specs = append(specs, "otlp+"+*otlpFlag)
+	}
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	headers, err := parseOTLPHeaders(firstNonEmpty(*otlpHeaders, os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")))
+	if err != nil {
+		return nil, fmt.Errorf("-otlp-headers: %w", err)
+	}
+	if *sinkBatch <= 0 || *sinkMaxPending <= 0 || *sinkFlush <= 0 || *sinkTimeout <= 0 {
+		return nil, fmt.Errorf("-sink-batch, -sink-max-pending, -sink-flush and -sink-timeout must be positive")
+	}
+	opts := sink.Options{
+		Token:         *influxToken,
+		Headers:       headers,
+		Protocol:      firstNonEmpty(*otlpProtocol, os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")),
+		BatchSize:     *sinkBatch,
+		FlushInterval: *sinkFlush,
+		MaxPending:    *sinkMaxPending,
+		Timeout:       *sinkTimeout,
+	}
+	var sinks []*sink.Batcher
+	for _, spec := range specs {
+		s, err := sink.Open(spec, opts)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		u, _ := url.Parse(spec)
+		sinks = append(sinks, sink.NewBatcher(u.Redacted(), s, opts))
+	}
+	return sinks, nil
+}
+
+// closeSinks flushes and closes sinks, logging what could not be written.
+func closeSinks(sinks []*sink.Batcher) {
+	for _, b := range sinks {
+		if err := b.Close(); err != nil {
+			st := b.Stats()
+			log.Printf("Sink %s error: %v (%d samples written, %d dropped)", b.Name, err, st.Sent, st.Dropped)
+		}
+	}
+}
+
+// parseOTLPHeaders parses k=v,... as in OTEL_EXPORTER_OTLP_HEADERS, where
+// values may be percent-encoded.
+func parseOTLPHeaders(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	headers := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid header %q (want key=value)", kv)
+		}
+		v, err := url.PathUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", k, err)
+		}
+		headers[strings.TrimSpace(k)] = v
+	}
+	return headers, nil
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,254 @@
+package accounting
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// Grouping keys supported by Options.GroupBy.
+const (
+	ByUser  = "user"
+	ByGroup = "group"
+	ByGPU   = "gpu"
+	ByHost  = "host"
+)
+
+// DefaultMaxGap caps how long a single sample may be accounted for, so that
+// periods where the recorder was not running are not billed.
+const DefaultMaxGap = 5 * time.Minute
+
+// MaxGapFor returns the MaxGap for samples recorded every interval: twice
+// the interval, and at least DefaultMaxGap.
+func MaxGapFor(interval time.Duration) time.Duration {
+	return max(DefaultMaxGap, 2*interval)
+}
+
+// Options controls how samples are integrated.
+type Options struct {
+	GroupBy    string            // ByUser (default), ByGroup, ByGPU or ByHost
+	MaxGap     time.Duration     // longest interval one sample may cover (default DefaultMaxGap)
+	UserGroups map[string]string // username -> group, used by ByGroup
+	Host       string            // only account this hostname ("" = all hosts)
+}
+
+// Usage is the integrated consumption of one key over a period.
+type Usage struct {
+	Key        string
+	GPUHours   float64 // GPU occupancy, split by memory share when a GPU is shared
+	MemGBHours float64
+	EnergyKWh  float64            // GPU power draw attributed by the same share
+	ByModel    map[string]float64 // GPU-hours per GPU model name
+}
+
+// Report is the result of integrating all samples in [From, To).
+type Report struct {
+	From    time.Time
+	To      time.Time
+	GroupBy string
+	Samples int
+	Rows    []Usage
+}
+
+// Total sums all rows of the report.
+func (r Report) Total() Usage {
+	t := Usage{Key: "TOTAL"}
+	for _, u := range r.Rows {
+		t.GPUHours += u.GPUHours
+		t.MemGBHours += u.MemGBHours
+		t.EnergyKWh += u.EnergyKWh
+	}
+	return t
+}
+
+// Integrator holds each snapshot until the next one from the same host
+// arrives and then passes it to fn with the time it covers, capped at
+// maxGap. At Finish the last snapshot of each host covers the time up to
+// the end of the period, but no longer than the host's previous interval.
+type Integrator struct {
+	maxGap  time.Duration
+	fn      func(s types.Snapshot, dt time.Duration)
+	prev    map[string]*types.Snapshot // by host key
+	lastDT  map[string]time.Duration
+	samples int
+}
+
+// NewIntegrator returns an Integrator calling fn; maxGap <= 0 means
+// DefaultMaxGap.
+func NewIntegrator(maxGap time.Duration, fn func(s types.Snapshot, dt time.Duration)) *Integrator {
+	if maxGap <= 0 {
+		maxGap = DefaultMaxGap
+	}
+	return &Integrator{
+		maxGap: maxGap,
+		fn:     fn,
+		prev:   make(map[string]*types.Snapshot),
+		lastDT: make(map[string]time.Duration),
+	}
+}
+
+// Add feeds the next snapshot; snapshots of each host must arrive in time order.
+func (in *Integrator) Add(s types.Snapshot) {
+	in.samples++
+	hk := s.Host.Key()
+	if prev, ok := in.prev[hk]; ok {
+		dt := min(s.TS.Sub(prev.TS), in.maxGap)
+		if dt > 0 {
+			in.fn(*prev, dt)
+			in.lastDT[hk] = dt
+		}
+	}
+	in.prev[hk] = &s
+}
+
+// Finish accounts the held snapshot of each host up to end, in host order.
+func (in *Integrator) Finish(end time.Time) {
+	hosts := make([]string, 0, len(in.prev))
+	for hk := range in.prev {
+		hosts = append(hosts, hk)
+	}
+	sort.Strings(hosts)
+	for _, hk := range hosts {
+		prev := in.prev[hk]
+		limit := in.maxGap
+		if last := in.lastDT[hk]; last > 0 && last < limit {
+			limit = last
+		}
+		if dt := min(end.Sub(prev.TS), limit); dt > 0 {
+			in.fn(*prev, dt)
+		}
+		delete(in.prev, hk)
+	}
+}
+
+// Samples returns the number of snapshots added.
+func (in *Integrator) Samples() int { return in.samples }
+
+// Accumulator integrates usage over consecutive snapshots with an Integrator.
+type Accumulator struct {
+	opts  Options
+	win   *Integrator
+	usage map[string]*Usage
+}
+
+func NewAccumulator(opts Options) *Accumulator {
+	if opts.GroupBy == "" {
+		opts.GroupBy = ByUser
+	}
+	if opts.MaxGap <= 0 {
+		opts.MaxGap = DefaultMaxGap
+	}
+	a := &Accumulator{opts: opts, usage: make(map[string]*Usage)}
+	a.win = NewIntegrator(opts.MaxGap, a.integrate)
+	return a
+}
+
+// Add feeds the next snapshot; snapshots of each host must arrive in time order.
+func (a *Accumulator) Add(s types.Snapshot) { a.win.Add(s) }
+
+// Finish accounts the last sample of each host up to end (at most one
+// sampling interval) and returns the rows sorted by GPU-hours.
+func (a *Accumulator) Finish(end time.Time) []Usage {
+	a.win.Finish(end)
+	rows := make([]Usage, 0, len(a.usage))
+	for _, u := range a.usage {
+		rows = append(rows, *u)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].GPUHours != rows[j].GPUHours {
+			return rows[i].GPUHours > rows[j].GPUHours
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	return rows
+}
+
+func (a *Accumulator) integrate(s types.Snapshot, dt time.Duration) {
+	hours := dt.Hours()
+	gpus := make(map[string]types.GPU, len(s.GPUs))
+	for _, g := range s.GPUs {
+		gpus[g.UUID] = g
+	}
+	byGPU := make(map[string][]types.GPUProcess)
+	for _, p := range s.Procs {
+		byGPU[p.GPUUUID] = append(byGPU[p.GPUUUID], p)
+	}
+	for uuid, procs := range byGPU {
+		var totalMem float64
+		for _, p := range procs {
+			totalMem += p.UsedMemMB
+		}
+		for _, p := range procs {
+			share := 1 / float64(len(procs))
+			if totalMem > 0 {
+				share = p.UsedMemMB / totalMem
+			}
+			g := gpus[uuid]
+			u := a.row(a.keyFor(s.Host, p, uuid, gpus))
+			u.GPUHours += share * hours
+			u.MemGBHours += p.UsedMemMB / 1024 * hours
+			u.EnergyKWh += share * g.PowerDrawW / 1000 * hours
+			model := g.Name
+			if model == "" {
+				model = "unknown"
+			}
+			u.ByModel[model] += share * hours
+		}
+	}
+}
+
+func (a *Accumulator) row(key string) *Usage {
+	u, ok := a.usage[key]
+	if !ok {
+		u = &Usage{Key: key, ByModel: make(map[string]float64)}
+		a.usage[key] = u
+	}
+	return u
+}
+
+func (a *Accumulator) keyFor(h types.Host, p types.GPUProcess, uuid string, gpus map[string]types.GPU) string {
+	switch a.opts.GroupBy {
+	case ByHost:
+		if h.Hostname == "" {
+			return "unknown"
+		}
+		return h.Hostname
+	case ByGroup:
+		if g, ok := a.opts.UserGroups[p.User]; ok {
+			return g
+		}
+		return "unknown"
+	case ByGPU:
+		key := "GPU " + uuid
+		if g, ok := gpus[uuid]; ok {
+			key = fmt.Sprintf("GPU %d (%s)", g.Index, g.Name)
+		}
+		if h.Hostname != "" {
+			key = h.Hostname + "/" + key
+		}
+		return key
+	default:
+		return p.User
+	}
+}
+
+// Compute integrates all stored snapshots with from <= ts < to.
+func Compute(db store.Store, from, to time.Time, opts Options) (Report, error) {
+	acc := NewAccumulator(opts)
+	if err := db.WalkRange(from, to, opts.Host, func(s types.Snapshot) error {
+		acc.Add(s)
+		return nil
+	}); err != nil {
+		return Report{}, err
+	}
+	return Report{
+		From:    from,
+		To:      to,
+		GroupBy: acc.opts.GroupBy,
+		Samples: acc.win.Samples(),
+		Rows:    acc.Finish(to),
+	}, nil
+}
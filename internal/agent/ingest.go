@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// maxBatchBytes bounds an ingest request; a full spool file is far smaller.
+const maxBatchBytes = 64 << 20
+
+// IngestResult is the aggregator's reply to a batch.
+type IngestResult struct {
+	Imported int
+	Skipped  int // already stored, e.g. a retried batch
+}
+
+// Handler accepts batches at IngestPath and stores them in db with
+// ImportSnapshot, so retries are not stored twice. Requests must carry
+// token as a bearer token unless token is empty. fn, if not nil, is called
+// with every newly stored snapshot.
+func Handler(db store.Store, token string, fn func(types.Snapshot)) http.Handler {
+	var mu sync.Mutex // one writer at a time keeps SQLite out of lock contention
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+		var batch []types.Snapshot
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes)).Decode(&batch); err != nil {
+			code := http.StatusBadRequest
+			if _, ok := err.(*http.MaxBytesError); ok {
+				code = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, "invalid batch: "+err.Error(), code)
+			return
+		}
+		for i, s := range batch {
+			if s.Host.Hostname == "" {
+				http.Error(w, fmt.Sprintf("snapshot %d: no host", i), http.StatusBadRequest)
+				return
+			}
+			if err := store.Validate(s); err != nil {
+				http.Error(w, fmt.Sprintf("snapshot %d: %v", i, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		var res IngestResult
+		for _, s := range batch {
+			id, imported, err := db.ImportSnapshot(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !imported {
+				res.Skipped++
+				continue
+			}
+			res.Imported++
+			if fn != nil {
+				s.ID = id
+				fn(s)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+func testSnapshot(i int) types.Snapshot {
+	return types.Snapshot{
+		TS:    time.Unix(int64(1e9+60*i), 0),
+		Host:  types.Host{Hostname: "node1", MachineID: "m-node1"},
+		GPUs:  []types.GPU{{Index: 0, UUID: "GPU-0", MemUsedMB: 1000, MemTotalMB: 40960}},
+		Procs: []types.GPUProcess{{PID: 100 + i, GPUUUID: "GPU-0", UsedMemMB: 1000, User: "alice"}},
+	}
+}
+
+func openStore(t *testing.T) *store.DB {
+	t.Helper()
+	db, err := store.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func post(h http.Handler, token string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, IngestPath, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerAuth(t *testing.T) {
+	var stored []types.Snapshot
+	h := Handler(openStore(t), "s3cret", func(s types.Snapshot) { stored = append(stored, s) })
+	batch, _ := json.Marshal([]types.Snapshot{testSnapshot(0), testSnapshot(1)})
+
+	for _, token := range []string{"", "wrong", "s3cret2"} {
+		if rec := post(h, token, bytes.NewReader(batch)); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: %d, want 401", token, rec.Code)
+		}
+	}
+	if len(stored) != 0 {
+		t.Fatalf("stored %d snapshots without a valid token", len(stored))
+	}
+
+	for _, want := range []IngestResult{{Imported: 2}, {Skipped: 2}} { // the second is a retry
+		rec := post(h, "s3cret", bytes.NewReader(batch))
+		var res IngestResult
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &res) != nil || res != want {
+			t.Errorf("POST = %d %s, want %+v", rec.Code, rec.Body, want)
+		}
+	}
+	if len(stored) != 2 || stored[0].ID == 0 {
+		t.Errorf("fn called with %+v, want two stored snapshots", stored)
+	}
+}
+
+// spaces is an endless JSON whitespace reader.
+type spaces struct{}
+
+func (spaces) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = ' '
+	}
+	return len(b), nil
+}
+
+func TestHandlerRejects(t *testing.T) {
+	h := Handler(openStore(t), "", nil)
+	if rec := post(h, "", io.LimitReader(spaces{}, maxBatchBytes+1)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized batch: %d, want 413", rec.Code)
+	}
+	noHost := testSnapshot(0)
+	noHost.Host = types.Host{}
+	badPID := testSnapshot(0)
+	badPID.Procs[0].PID = -1
+	for name, s := range map[string]types.Snapshot{"no host": noHost, "bad PID": badPID} {
+		batch, _ := json.Marshal([]types.Snapshot{testSnapshot(1), s})
+		if rec := post(h, "", bytes.NewReader(batch)); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", name, rec.Code)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, IngestPath, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: %d, want 405", rec.Code)
+	}
+}
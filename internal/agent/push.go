@@ -0,0 +1,414 @@
+// Package agent ships snapshots from sampling hosts to a central aggregator
+// over HTTP. Agents retry and spool batches to disk while the aggregator is
+// unreachable; the aggregator stores batches idempotently, so a batch that
+// is delivered twice is only stored once.
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// IngestPath is where an aggregator accepts batches: a JSON array of
+// snapshots, authorized with "Authorization: Bearer <token>" when the
+// aggregator has a token.
+const IngestPath = "/api/v1/ingest"
+
+// Defaults for Options.
+const (
+	DefaultBatchSize     = 64
+	DefaultMaxSpoolBytes = 256 << 20
+	DefaultQueueSize     = 1024
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+
+	// spoolFileBytes is the size at which a new spool file is started; each
+	// file is delivered in one request.
+	spoolFileBytes = 1 << 20
+)
+
+// Options configures a Pusher.
+type Options struct {
+	URL           string // aggregator base URL, e.g. http://central:9400
+	Token         string
+	SpoolDir      string // batches that could not be delivered wait here
+	MaxSpoolBytes int64  // oldest spooled batches are discarded beyond this
+	BatchSize     int    // snapshots per request
+	Client        *http.Client
+}
+
+// Stats reports delivery progress.
+type Stats struct {
+	Sent     uint64 // snapshots accepted by the aggregator
+	Spooled  int    // snapshots waiting on disk
+	Dropped  uint64 // discarded: queue full, spool full or rejected by the aggregator
+	Errors   uint64 // failed deliveries
+	LastErr  error
+	Degraded bool // the aggregator was unreachable at the last attempt
+}
+
+// Pusher delivers snapshots in the background in the order they were
+// enqueued, spooled batches first.
+type Pusher struct {
+	opts  Options
+	url   string
+	queue chan types.Snapshot
+	done  chan struct{}
+	err   error // of the final delivery, set before done is closed
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// errRejected marks a batch the aggregator refused as invalid; retrying it
+// cannot succeed.
+var errRejected = errors.New("rejected by aggregator")
+
+// NewPusher creates the spool directory and starts delivering.
+func NewPusher(opts Options) (*Pusher, error) {
+	if opts.URL == "" {
+		return nil, errors.New("agent: no aggregator URL")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.MaxSpoolBytes <= 0 {
+		opts.MaxSpoolBytes = DefaultMaxSpoolBytes
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 15 * time.Second}
+	}
+	if err := os.MkdirAll(opts.SpoolDir, 0o700); err != nil {
+		return nil, err
+	}
+	p := &Pusher{
+		opts:  opts,
+		url:   strings.TrimRight(opts.URL, "/") + IngestPath,
+		queue: make(chan types.Snapshot, DefaultQueueSize),
+		done:  make(chan struct{}),
+	}
+	files, _ := p.spoolFiles()
+	for _, f := range files {
+		p.stats.Spooled += countLines(f)
+	}
+	go p.run()
+	return p, nil
+}
+
+// Enqueue queues s without blocking; it returns false and counts the
+// snapshot as dropped when the queue is full. It must not be called after
+// Close.
+func (p *Pusher) Enqueue(s types.Snapshot) bool {
+	s.ID = 0 // IDs are local to each store
+	select {
+	case p.queue <- s:
+		return true
+	default:
+		p.mu.Lock()
+		p.stats.Dropped++
+		p.mu.Unlock()
+		return false
+	}
+}
+
+// Close stops the pusher after one last delivery attempt; whatever cannot
+// be delivered stays in the spool for the next run.
+func (p *Pusher) Close() error {
+	close(p.queue)
+	<-p.done
+	return p.err
+}
+
+// Stats returns a copy of the delivery counters.
+func (p *Pusher) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+func (p *Pusher) run() {
+	defer close(p.done)
+	backoff := time.Duration(0)
+	retry := time.NewTimer(0) // drain a spool left by a previous run
+	defer retry.Stop()
+	for {
+		select {
+		case s, ok := <-p.queue:
+			if !ok {
+				p.err = p.final()
+				return
+			}
+			batch := p.collect(s)
+			if p.Stats().Spooled > 0 {
+				// Keep order: queue behind the spooled batches.
+				p.spool(batch)
+				continue
+			}
+			if err := p.post(batch); err != nil {
+				p.fail(err, batch)
+				backoff = nextBackoff(backoff)
+				retry.Reset(backoff)
+			}
+		case <-retry.C:
+			if err := p.drain(); err != nil {
+				backoff = nextBackoff(backoff)
+				retry.Reset(backoff)
+			} else {
+				backoff = 0
+			}
+		}
+	}
+}
+
+// collect adds whatever else is queued to s, up to BatchSize snapshots.
+func (p *Pusher) collect(s types.Snapshot) []types.Snapshot {
+	batch := []types.Snapshot{s}
+	for len(batch) < p.opts.BatchSize {
+		select {
+		case s, ok := <-p.queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, s)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// final delivers the spool and the rest of the queue once, spooling
+// anything that fails.
+func (p *Pusher) final() error {
+	var rest []types.Snapshot
+	for s := range p.queue {
+		rest = append(rest, s)
+	}
+	if err := p.drain(); err != nil {
+		p.spool(rest)
+		return err
+	}
+	for len(rest) > 0 {
+		n := min(len(rest), p.opts.BatchSize)
+		if err := p.post(rest[:n]); err != nil {
+			p.fail(err, rest)
+			return err
+		}
+		rest = rest[n:]
+	}
+	return nil
+}
+
+func (p *Pusher) fail(err error, batch []types.Snapshot) {
+	p.mu.Lock()
+	p.stats.Errors++
+	p.stats.LastErr = err
+	p.mu.Unlock()
+	if errors.Is(err, errRejected) {
+		p.mu.Lock()
+		p.stats.Dropped += uint64(len(batch))
+		p.mu.Unlock()
+		return
+	}
+	p.spool(batch)
+}
+
+// drain delivers spooled batches oldest first and stops at the first
+// failure.
+func (p *Pusher) drain() error {
+	files, err := p.spoolFiles()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		n := countLines(f)
+		batch, err := readSpool(f)
+		if err == nil && len(batch) > 0 {
+			err = p.post(batch)
+		}
+		if err != nil && !errors.Is(err, errRejected) {
+			p.mu.Lock()
+			p.stats.Errors++
+			p.stats.LastErr = err
+			p.mu.Unlock()
+			return err
+		}
+		os.Remove(f)
+		p.mu.Lock()
+		p.stats.Spooled -= n
+		if err != nil {
+			p.stats.Dropped += uint64(n)
+		} else {
+			p.stats.Dropped += uint64(n - len(batch))
+		}
+		p.mu.Unlock()
+	}
+	p.mu.Lock()
+	p.stats.Spooled = 0
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Pusher) post(batch []types.Snapshot) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.opts.Token)
+	}
+	resp, err := p.opts.Client.Do(req)
+	if err != nil {
+		p.setDegraded(true)
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode/100 == 2:
+		p.mu.Lock()
+		p.stats.Sent += uint64(len(batch))
+		p.stats.Degraded = false
+		p.mu.Unlock()
+		return nil
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %s: %s", errRejected, resp.Status, bytes.TrimSpace(msg))
+	default:
+		p.setDegraded(true)
+		return fmt.Errorf("aggregator: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+}
+
+func (p *Pusher) setDegraded(v bool) {
+	p.mu.Lock()
+	p.stats.Degraded = v
+	p.mu.Unlock()
+}
+
+// spool appends batch to the newest spool file, or starts a file named by
+// the current time once it is full, so lexical order is delivery order. The
+// spool is then trimmed to MaxSpoolBytes.
+func (p *Pusher) spool(batch []types.Snapshot) {
+	if len(batch) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, s := range batch {
+		if err := enc.Encode(s); err != nil {
+			return
+		}
+	}
+	name := filepath.Join(p.opts.SpoolDir, fmt.Sprintf("%020d.jsonl", time.Now().UnixNano()))
+	if files, _ := p.spoolFiles(); len(files) > 0 {
+		if st, err := os.Stat(files[len(files)-1]); err == nil && st.Size() < spoolFileBytes {
+			name = files[len(files)-1]
+		}
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err == nil {
+		_, err = f.Write(buf.Bytes())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	p.mu.Lock()
+	if err != nil {
+		p.stats.Errors++
+		p.stats.LastErr = fmt.Errorf("spool: %w", err)
+		p.stats.Dropped += uint64(len(batch))
+	} else {
+		p.stats.Spooled += len(batch)
+	}
+	p.mu.Unlock()
+	p.trim()
+}
+
+// trim discards the oldest spooled batches while the spool exceeds
+// MaxSpoolBytes.
+func (p *Pusher) trim() {
+	files, err := p.spoolFiles()
+	if err != nil {
+		return
+	}
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, f := range files {
+		if st, err := os.Stat(f); err == nil {
+			sizes[i] = st.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; total > p.opts.MaxSpoolBytes && i < len(files)-1; i++ {
+		n := countLines(files[i])
+		if os.Remove(files[i]) == nil {
+			total -= sizes[i]
+			p.mu.Lock()
+			p.stats.Spooled -= n
+			p.stats.Dropped += uint64(n)
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *Pusher) spoolFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(p.opts.SpoolDir, "*.jsonl"))
+	sort.Strings(files)
+	return files, err
+}
+
+// readSpool loads a spool file, skipping lines that cannot be decoded, such
+// as a batch cut short by a crash.
+func readSpool(path string) ([]types.Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var batch []types.Snapshot
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for sc.Scan() {
+		var s types.Snapshot
+		if json.Unmarshal(sc.Bytes(), &s) == nil {
+			batch = append(batch, s)
+		}
+	}
+	return batch, sc.Err()
+}
+
+func countLines(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	return bytes.Count(b, []byte{'\n'})
+}
+
+func nextBackoff(d time.Duration) time.Duration {
+	if d < minBackoff {
+		return minBackoff
+	}
+	return min(2*d, maxBackoff)
+}
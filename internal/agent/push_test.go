@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the timeout expires.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestPusherSpoolsWhileDown checks that batches spool while the aggregator
+// fails and are delivered in order once it recovers.
+func TestPusherSpoolsWhileDown(t *testing.T) {
+	db := openStore(t)
+	var down atomic.Bool
+	down.Store(true)
+	ingest := Handler(db, "s3cret", nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "restarting", http.StatusServiceUnavailable)
+			return
+		}
+		ingest.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	p, err := NewPusher(Options{URL: srv.URL, Token: "s3cret", SpoolDir: t.TempDir(), BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		p.Enqueue(testSnapshot(i))
+	}
+	waitFor(t, 5*time.Second, "3 spooled snapshots", func() bool { return p.Stats().Spooled == 3 })
+	if st := p.Stats(); !st.Degraded || st.Sent != 0 || st.LastErr == nil {
+		t.Errorf("stats while down = %+v", st)
+	}
+
+	down.Store(false)
+	p.Enqueue(testSnapshot(3)) // queued behind the spool
+	waitFor(t, 5*time.Second, "the spool to drain", func() bool { return p.Stats().Sent == 4 })
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := p.Stats(); st.Spooled != 0 || st.Dropped != 0 || st.Degraded {
+		t.Errorf("stats after recovery = %+v", st)
+	}
+	files, _ := p.spoolFiles()
+	if len(files) != 0 {
+		t.Errorf("spool files left: %v", files)
+	}
+
+	ms, err := db.ListSnapshotsRange(time.Unix(0, 0), time.Unix(2e9, 0), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 4 {
+		t.Fatalf("aggregator stored %d snapshots, want 4", len(ms))
+	}
+	for i, m := range ms { // IDs follow the order of delivery
+		if !m.TS.Equal(testSnapshot(i).TS) || i > 0 && m.ID < ms[i-1].ID {
+			t.Errorf("snapshot %d delivered out of order: %+v", i, m)
+		}
+	}
+}
+
+// TestPusherSpoolSurvivesRestart checks that a spool left by Close is
+// delivered by the next Pusher.
+func TestPusherSpoolSurvivesRestart(t *testing.T) {
+	db := openStore(t)
+	dir := t.TempDir()
+	p, err := NewPusher(Options{URL: "http://127.0.0.1:1", SpoolDir: dir}) // nothing listens
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Enqueue(testSnapshot(0))
+	p.Enqueue(testSnapshot(1))
+	if err := p.Close(); err == nil {
+		t.Fatal("Close with the aggregator down succeeded")
+	}
+
+	srv := httptest.NewServer(Handler(db, "", nil))
+	defer srv.Close()
+	p, err = NewPusher(Options{URL: srv.URL, SpoolDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "the spool to drain", func() bool { return p.Stats().Sent == 2 })
+	p.Close()
+	if s, err := db.LoadLatest("node1"); err != nil || !s.TS.Equal(testSnapshot(1).TS) {
+		t.Errorf("latest stored = %v, %v", s.TS, err)
+	}
+}
@@ -0,0 +1,365 @@
+// Package api serves the live sample and the snapshot history as JSON over
+// HTTP. Snapshots are encoded as types.Snapshot; lists are paginated.
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"gpuwatch/internal/accounting"
+	"gpuwatch/internal/fleet"
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// Pagination defaults for list endpoints (?limit=&offset=).
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Live provides the most recent sample; *sampler.Poller implements it. With
+// a Snapshots method, as *sampler.HostPoller has, ?host= picks that host.
+type Live interface {
+	Latest() (types.Snapshot, bool)
+}
+
+// Server routes /api/v1/. Either source may be nil: without a store the
+// history endpoints answer 503, without a live sampler the latest snapshot
+// is read from the store.
+type Server struct {
+	// Fleet holds the alert thresholds of /api/v1/fleet.
+	Fleet fleet.Config
+	// MaxGap is the accounting.Options.MaxGap of /api/v1/usage.
+	MaxGap time.Duration
+
+	db   store.Store
+	live Live
+	loc  *time.Location
+	mux  *http.ServeMux
+}
+
+// New returns the API handler. Dates and times without a zone in query
+// parameters are read in loc.
+func New(db store.Store, live Live, loc *time.Location) *Server {
+	if loc == nil {
+		loc = time.Local
+	}
+	s := &Server{db: db, live: live, loc: loc, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /api/v1/snapshot/latest", s.latest)
+	s.mux.HandleFunc("GET /api/v1/snapshots", s.snapshots)
+	s.mux.HandleFunc("GET /api/v1/snapshots/{id}", s.snapshot)
+	s.mux.HandleFunc("GET /api/v1/hosts", s.hosts)
+	s.mux.HandleFunc("GET /api/v1/fleet", s.fleet)
+	s.mux.HandleFunc("GET /api/v1/users", s.users)
+	s.mux.HandleFunc("GET /api/v1/usage", s.usage)
+	s.mux.HandleFunc("GET /api/v1/series", s.series)
+	s.mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.mux.ServeHTTP(w, r) }
+
+// Page is the envelope of paginated lists.
+type Page[T any] struct {
+	Total  int
+	Offset int
+	Limit  int
+	Items  []T
+}
+
+// UserSummary is one user's share of a snapshot.
+type UserSummary struct {
+	User      string
+	MemUsedMB float64
+	Procs     int
+	GPUs      int // distinct GPUs the user has processes on
+}
+
+// latest serves the live sample when it matches ?host=, else the newest
+// stored snapshot.
+func (s *Server) latest(w http.ResponseWriter, r *http.Request) {
+	snap, err := s.current(r.URL.Query().Get("host"))
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, snap)
+}
+
+func (s *Server) current(host string) (types.Snapshot, error) {
+	if m, ok := s.live.(interface{ Snapshots() []types.Snapshot }); ok && host != "" {
+		for _, snap := range m.Snapshots() {
+			if snap.Host.Hostname == host {
+				return snap, nil
+			}
+		}
+	} else if s.live != nil {
+		if snap, ok := s.live.Latest(); ok && (host == "" || snap.Host.Hostname == host) {
+			return snap, nil
+		}
+	}
+	if s.db == nil {
+		return types.Snapshot{}, store.ErrNoSnapshots
+	}
+	return s.db.LoadLatest(host)
+}
+
+// snapshots lists snapshot metas of ?date= (a calendar day) or of
+// ?from=&to=, oldest first, optionally for one ?host=.
+func (s *Server) snapshots(w http.ResponseWriter, r *http.Request) {
+	if !s.needStore(w) {
+		return
+	}
+	q := r.URL.Query()
+	var metas []store.SnapshotMeta
+	var err error
+	if d := q.Get("date"); d != "" {
+		day, perr := time.ParseInLocation("2006-01-02", d, s.loc)
+		if perr != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid date %q (use YYYY-MM-DD)", d))
+			return
+		}
+		metas, err = s.db.ListSnapshotsByDate(day, q.Get("host"))
+	} else {
+		from, to, rerr := s.timeRange(r, 24*time.Hour)
+		if rerr != nil {
+			writeError(w, http.StatusBadRequest, rerr.Error())
+			return
+		}
+		metas, err = s.db.ListSnapshotsRange(from, to, q.Get("host"))
+	}
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	for i := range metas {
+		metas[i].TS = metas[i].TS.In(s.loc)
+	}
+	page, err := paginate(r, metas)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, page)
+}
+
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
+	if !s.needStore(w) {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid snapshot id %q", r.PathValue("id")))
+		return
+	}
+	snap, err := s.db.LoadSnapshot(id)
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, snap)
+}
+
+func (s *Server) hosts(w http.ResponseWriter, r *http.Request) {
+	if !s.needStore(w) {
+		return
+	}
+	hosts, err := s.db.ListHosts()
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, hosts)
+}
+
+// fleet summarizes the latest snapshot of every host: GPU counts, free
+// GPUs, utilization and alerts, plus per-user totals across hosts.
+func (s *Server) fleet(w http.ResponseWriter, r *http.Request) {
+	if !s.needStore(w) {
+		return
+	}
+	snaps, err := fleet.Load(s.db)
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	sum := fleet.Summarize(snaps, time.Now(), s.Fleet)
+	for i := range sum.Hosts {
+		sum.Hosts[i].TS = sum.Hosts[i].TS.In(s.loc)
+	}
+	writeJSON(w, sum)
+}
+
+// users aggregates GPU memory and processes per user of the latest snapshot,
+// or of snapshot ?id=, largest first.
+func (s *Server) users(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var snap types.Snapshot
+	var err error
+	if v := q.Get("id"); v != "" {
+		if !s.needStore(w) {
+			return
+		}
+		id, perr := strconv.ParseInt(v, 10, 64)
+		if perr != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid snapshot id %q", v))
+			return
+		}
+		snap, err = s.db.LoadSnapshot(id)
+	} else {
+		snap, err = s.current(q.Get("host"))
+	}
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, Users(snap))
+}
+
+// Users returns the per-user totals of snap sorted by memory, largest first.
+func Users(snap types.Snapshot) []UserSummary {
+	byUser := make(map[string]*UserSummary)
+	gpus := make(map[string]map[string]bool)
+	var out []UserSummary
+	var order []string
+	for _, p := range snap.Procs {
+		u, ok := byUser[p.User]
+		if !ok {
+			u = &UserSummary{User: p.User}
+			byUser[p.User] = u
+			gpus[p.User] = make(map[string]bool)
+			order = append(order, p.User)
+		}
+		u.MemUsedMB += p.UsedMemMB
+		u.Procs++
+		gpus[p.User][p.GPUUUID] = true
+	}
+	for _, name := range order {
+		u := byUser[name]
+		u.GPUs = len(gpus[name])
+		out = append(out, *u)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].MemUsedMB > out[j].MemUsedMB })
+	return out
+}
+
+// usage integrates GPU-hours, memory and energy over ?from=&to= (default:
+// the last 24 hours), grouped by ?by=user|gpu|host.
+func (s *Server) usage(w http.ResponseWriter, r *http.Request) {
+	if !s.needStore(w) {
+		return
+	}
+	q := r.URL.Query()
+	from, to, err := s.timeRange(r, 24*time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	by := q.Get("by")
+	switch by {
+	case "", accounting.ByUser, accounting.ByGPU, accounting.ByHost:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid by %q (use user, gpu or host)", by))
+		return
+	}
+	rep, err := accounting.Compute(s.db, from, to, accounting.Options{GroupBy: by, Host: q.Get("host"), MaxGap: s.MaxGap})
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	rep.From, rep.To = rep.From.In(s.loc), rep.To.In(s.loc)
+	writeJSON(w, rep)
+}
+
+// timeRange reads ?from= and ?to= (default: now and span before it).
+func (s *Server) timeRange(r *http.Request, span time.Duration) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+	to, err := s.parseTime(q.Get("to"), time.Now().In(s.loc))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	from, err := s.parseTime(q.Get("from"), to.Add(-span))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+// parseTime accepts the same forms as the -from/-to flags.
+func (s *Server) parseTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, v, s.loc); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (use YYYY-MM-DD, \"YYYY-MM-DD HH:MM\" or RFC3339)", v)
+	}
+	return t.In(s.loc), nil
+}
+
+func paginate[T any](r *http.Request, items []T) (Page[T], error) {
+	q := r.URL.Query()
+	p := Page[T]{Total: len(items), Limit: DefaultLimit, Items: []T{}}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("invalid limit %q", v)
+		}
+		p.Limit = min(n, MaxLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid offset %q", v)
+		}
+		p.Offset = n
+	}
+	if p.Offset < len(items) {
+		p.Items = items[p.Offset:min(p.Offset+p.Limit, len(items))]
+	}
+	return p, nil
+}
+
+func (s *Server) needStore(w http.ResponseWriter) bool {
+	if s.db == nil {
+		writeError(w, http.StatusServiceUnavailable, "no history store")
+		return false
+	}
+	return true
+}
+
+func (s *Server) fail(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrNoSnapshots) || errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "snapshot not found")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/accounting"
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+var t0 = time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+
+func alpha(ts time.Time) types.Snapshot {
+	return types.Snapshot{
+		TS:   ts,
+		Host: types.Host{Hostname: "alpha", MachineID: "m-alpha"},
+		GPUs: []types.GPU{
+			{Index: 0, Name: "A100", UUID: "GPU-a0", UtilGPU: 80, MemUsedMB: 1000, MemTotalMB: 40960, PowerDrawW: 300},
+			{Index: 1, Name: "A100", UUID: "GPU-a1", UtilGPU: 10, MemUsedMB: 500, MemTotalMB: 40960, PowerDrawW: 100},
+		},
+		Procs: []types.GPUProcess{
+			{PID: 1, ProcessName: "train", UsedMemMB: 1000, GPUUUID: "GPU-a0", User: "alice"},
+			{PID: 2, ProcessName: "eval", UsedMemMB: 500, GPUUUID: "GPU-a1", User: "bob"},
+			{PID: 3, ProcessName: "lost", UsedMemMB: 700, GPUUUID: "GPU-gone", User: "carol"}, // GPU not in the snapshot
+		},
+	}
+}
+
+func beta(ts time.Time) types.Snapshot {
+	return types.Snapshot{
+		TS:    ts,
+		Host:  types.Host{Hostname: "beta", MachineID: "m-beta"},
+		GPUs:  []types.GPU{{Index: 0, Name: "H100", UUID: "GPU-b0", UtilGPU: 50, MemUsedMB: 2000, MemTotalMB: 81920}},
+		Procs: []types.GPUProcess{{PID: 9, ProcessName: "serve", UsedMemMB: 2000, GPUUUID: "GPU-b0", User: "alice"}},
+	}
+}
+
+// newTestServer stores three minutes of samples of alpha and beta.
+func newTestServer(t *testing.T, live Live) (*Server, store.Store) {
+	t.Helper()
+	db, err := store.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for i := 0; i < 3; i++ {
+		ts := t0.Add(time.Duration(i) * time.Minute)
+		for _, s := range []types.Snapshot{alpha(ts), beta(ts.Add(time.Second))} {
+			if _, err := db.SaveSnapshot(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return New(db, live, time.UTC), db
+}
+
+// get requests path and decodes the JSON body into v, failing unless the
+// status is want.
+func get(t *testing.T, h http.Handler, path string, want int, v any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != want {
+		t.Fatalf("GET %s = %d %s, want %d", path, rec.Code, rec.Body, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s: Content-Type %q", path, ct)
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	var snap types.Snapshot
+	get(t, srv, "/api/v1/snapshot/latest", 200, &snap)
+	if snap.Host.Hostname != "beta" || !snap.TS.Equal(t0.Add(2*time.Minute+time.Second)) {
+		t.Errorf("latest = %s at %v, want beta at t0+2m1s", snap.Host.Hostname, snap.TS)
+	}
+	get(t, srv, "/api/v1/snapshot/latest?host=alpha", 200, &snap)
+	if snap.Host.Hostname != "alpha" || len(snap.GPUs) != 2 || len(snap.Procs) != 3 {
+		t.Errorf("latest alpha = %+v", snap)
+	}
+	get(t, srv, "/api/v1/snapshot/latest?host=gamma", 404, nil)
+}
+
+type fakeLive struct{ snap types.Snapshot }
+
+func (f fakeLive) Latest() (types.Snapshot, bool) { return f.snap, true }
+
+func TestLatestPrefersLive(t *testing.T) {
+	live := alpha(t0.Add(time.Hour))
+	srv, _ := newTestServer(t, fakeLive{live})
+	var snap types.Snapshot
+	get(t, srv, "/api/v1/snapshot/latest", 200, &snap)
+	if !snap.TS.Equal(live.TS) {
+		t.Errorf("latest at %v, want the live sample at %v", snap.TS, live.TS)
+	}
+	get(t, srv, "/api/v1/snapshot/latest?host=beta", 200, &snap) // live is alpha: read the store
+	if snap.Host.Hostname != "beta" {
+		t.Errorf("latest beta = %s", snap.Host.Hostname)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	srv, db := newTestServer(t, nil)
+	var page Page[store.SnapshotMeta]
+	get(t, srv, "/api/v1/snapshots?date=2026-03-10&limit=4&offset=1", 200, &page)
+	if page.Total != 6 || page.Offset != 1 || page.Limit != 4 || len(page.Items) != 4 || page.Items[0].Host != "beta" {
+		t.Errorf("page = %+v", page)
+	}
+	get(t, srv, "/api/v1/snapshots?from=2026-03-10T10:01:00Z&to=2026-03-10T10:02:00Z&host=alpha", 200, &page)
+	if page.Total != 1 || page.Items[0].Host != "alpha" || !page.Items[0].TS.Equal(t0.Add(time.Minute)) {
+		t.Errorf("range page = %+v", page)
+	}
+	get(t, srv, "/api/v1/snapshots?date=2026-3-10", 400, nil)
+	get(t, srv, "/api/v1/snapshots?limit=0", 400, nil)
+	get(t, srv, "/api/v1/snapshots?from=2026-03-11&to=2026-03-10", 400, nil)
+
+	want, err := db.LoadLatest("alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap types.Snapshot
+	get(t, srv, "/api/v1/snapshots/"+strconv.FormatInt(want.ID, 10), 200, &snap)
+	if snap.ID != want.ID || snap.Host.Hostname != "alpha" || len(snap.Procs) != 3 {
+		t.Errorf("snapshot %d = %+v", want.ID, snap)
+	}
+	get(t, srv, "/api/v1/snapshots/x", 400, nil)
+	get(t, srv, "/api/v1/snapshots/999", 404, nil)
+}
+
+func TestHostsAndUsers(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	var hosts []types.Host
+	get(t, srv, "/api/v1/hosts", 200, &hosts)
+	if len(hosts) != 2 || hosts[0].Hostname != "alpha" || hosts[1].Hostname != "beta" {
+		t.Errorf("hosts = %+v", hosts)
+	}
+	var users []UserSummary
+	get(t, srv, "/api/v1/users?host=alpha", 200, &users)
+	want := []UserSummary{
+		{User: "alice", MemUsedMB: 1000, Procs: 1, GPUs: 1},
+		{User: "carol", MemUsedMB: 700, Procs: 1, GPUs: 1},
+		{User: "bob", MemUsedMB: 500, Procs: 1, GPUs: 1},
+	}
+	if len(users) != len(want) {
+		t.Fatalf("users = %+v", users)
+	}
+	for i := range want {
+		if users[i] != want[i] {
+			t.Errorf("users[%d] = %+v, want %+v", i, users[i], want[i])
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	var rep accounting.Report
+	get(t, srv, "/api/v1/usage?by=host&from=2026-03-10&to=2026-03-11", 200, &rep)
+	if rep.GroupBy != accounting.ByHost || rep.Samples != 6 || len(rep.Rows) != 2 {
+		t.Fatalf("usage = %+v", rep)
+	}
+	// Three one-minute samples per host; alpha has processes on three GPU
+	// UUIDs, beta on one.
+	for _, u := range rep.Rows {
+		want := map[string]float64{"alpha": 3 * 3.0 / 60, "beta": 3.0 / 60}[u.Key]
+		if d := u.GPUHours - want; d > 1e-9 || d < -1e-9 {
+			t.Errorf("%s: %.4f GPU-hours, want %.4f", u.Key, u.GPUHours, want)
+		}
+	}
+	get(t, srv, "/api/v1/usage?by=group", 400, nil)
+}
+
+func TestSeries(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	var out []Series
+	get(t, srv, "/api/v1/series?metric=util&gpu=1&from=2026-03-10&to=2026-03-11", 200, &out)
+	if len(out) != 1 || out[0].Host != "alpha" || out[0].UUID != "GPU-a1" || len(out[0].Points) != 3 || out[0].Points[0].Value != 10 {
+		t.Errorf("util of GPU 1 = %+v", out)
+	}
+
+	out = nil // json reuses the elements of a non-empty slice
+	get(t, srv, "/api/v1/series?metric=user_mem_mb&gpu=0&host=alpha&from=2026-03-10&to=2026-03-11", 200, &out)
+	if len(out) != 1 || out[0].User != "alice" {
+		t.Errorf("user memory on GPU 0 = %+v, want only alice (carol's GPU is unknown)", out)
+	}
+
+	out = nil
+	get(t, srv, "/api/v1/series?metric=user_mem_mb&user=alice&step=1h&from=2026-03-10&to=2026-03-11", 200, &out)
+	if len(out) != 2 || len(out[0].Points) != 1 || !out[0].Points[0].TS.Equal(t0.Add(2*time.Minute)) {
+		t.Errorf("hourly alice = %+v, want the last point per host", out)
+	}
+
+	get(t, srv, "/api/v1/series?metric=fan", 400, nil)
+	get(t, srv, "/api/v1/series?gpu=-1", 400, nil)
+	get(t, srv, "/api/v1/series?step=0s", 400, nil)
+}
+
+func TestWithoutStore(t *testing.T) {
+	srv := New(nil, nil, time.UTC)
+	get(t, srv, "/api/v1/snapshots", 503, nil)
+	get(t, srv, "/api/v1/snapshot/latest", 404, nil)
+	get(t, srv, "/api/v1/nope", 404, nil)
+
+	srv = New(nil, fakeLive{beta(t0)}, time.UTC)
+	var users []UserSummary
+	get(t, srv, "/api/v1/users", 200, &users)
+	if len(users) != 1 || users[0].User != "alice" || users[0].MemUsedMB != 2000 {
+		t.Errorf("live users = %+v", users)
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// gpuMetrics are the per-GPU readings available from /api/v1/series.
+var gpuMetrics = map[string]func(types.GPU) float64{
+	"util":        func(g types.GPU) float64 { return g.UtilGPU },
+	"mem_util":    func(g types.GPU) float64 { return g.UtilMem },
+	"mem_used_mb": func(g types.GPU) float64 { return g.MemUsedMB },
+	"temp":        func(g types.GPU) float64 { return g.TempC },
+	"power":       func(g types.GPU) float64 { return g.PowerDrawW },
+}
+
+// userMetric is the per-user series: GPU memory summed over processes.
+const userMetric = "user_mem_mb"
+
+// Point is one value of a series.
+type Point struct {
+	TS    time.Time
+	Value float64
+}
+
+// Series is the history of one metric for one GPU (Index, UUID) or, for
+// user_mem_mb, one user.
+type Series struct {
+	Host   string
+	Index  int
+	UUID   string `json:",omitempty"`
+	User   string `json:",omitempty"`
+	Points []Point
+}
+
+// series returns ?metric= over ?from=&to= (default: the last hour), one
+// series per GPU or user, filtered by ?host=, ?gpu= and ?user=. With ?step=
+// (a duration) only the last point of every step is kept.
+func (s *Server) series(w http.ResponseWriter, r *http.Request) {
+	if !s.needStore(w) {
+		return
+	}
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	if metric == "" {
+		metric = "util"
+	}
+	value, isGPU := gpuMetrics[metric]
+	if !isGPU && metric != userMetric {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid metric %q (use util, mem_util, mem_used_mb, temp, power or %s)", metric, userMetric))
+		return
+	}
+	from, to, err := s.timeRange(r, time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	gpu := -1
+	if v := q.Get("gpu"); v != "" {
+		if gpu, err = strconv.Atoi(v); err != nil || gpu < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid gpu %q", v))
+			return
+		}
+	}
+	var step time.Duration
+	if v := q.Get("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil || step <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid step %q", v))
+			return
+		}
+	}
+	user := q.Get("user")
+
+	byKey := make(map[string]*Series)
+	var keys []string
+	add := func(key string, proto Series, ts time.Time, v float64) {
+		sr, ok := byKey[key]
+		if !ok {
+			sr = &proto
+			byKey[key] = sr
+			keys = append(keys, key)
+		}
+		p := Point{TS: ts.In(s.loc), Value: v}
+		if n := len(sr.Points); step > 0 && n > 0 && ts.Truncate(step).Equal(sr.Points[n-1].TS.Truncate(step)) {
+			sr.Points[n-1] = p
+			return
+		}
+		sr.Points = append(sr.Points, p)
+	}
+	err = s.db.WalkRange(from, to, q.Get("host"), func(snap types.Snapshot) error {
+		host := snap.Host.Hostname
+		if isGPU {
+			for _, g := range snap.GPUs {
+				if gpu >= 0 && g.Index != gpu {
+					continue
+				}
+				add(host+"\x00"+g.UUID, Series{Host: host, Index: g.Index, UUID: g.UUID}, snap.TS, value(g))
+			}
+			return nil
+		}
+		var index map[string]int
+		if gpu >= 0 {
+			index = make(map[string]int, len(snap.GPUs))
+			for _, g := range snap.GPUs {
+				index[g.UUID] = g.Index
+			}
+		}
+		mem := make(map[string]float64)
+		var users []string
+		for _, p := range snap.Procs {
+			if user != "" && p.User != user {
+				continue
+			}
+			if index != nil {
+				if i, ok := index[p.GPUUUID]; !ok || i != gpu {
+					continue
+				}
+			}
+			if _, ok := mem[p.User]; !ok {
+				users = append(users, p.User)
+			}
+			mem[p.User] += p.UsedMemMB
+		}
+		for _, u := range users {
+			add(host+"\x00"+u, Series{Host: host, User: u}, snap.TS, mem[u])
+		}
+		return nil
+	})
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	out := make([]Series, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *byKey[k])
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Host != out[j].Host {
+			return out[i].Host < out[j].Host
+		}
+		if out[i].Index != out[j].Index {
+			return out[i].Index < out[j].Index
+		}
+		return out[i].User < out[j].User
+	})
+	writeJSON(w, out)
+}
@@ -0,0 +1,150 @@
+package chargeback
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gpuwatch/internal/accounting"
+	"gpuwatch/internal/store"
+)
+
+// Unassigned is the group used for users missing from Config.Groups.
+const Unassigned = "unassigned"
+
+// Rate prices one hour of a GPU whose name contains Match (case-insensitive).
+type Rate struct {
+	Match   string  `json:"match"`
+	PerHour float64 `json:"per_hour"`
+}
+
+// Config holds cost rates and user/group/project mappings, loaded from JSON.
+type Config struct {
+	Currency       string            `json:"currency"`
+	GPURates       []Rate            `json:"gpu_rates"`        // first match wins
+	DefaultGPURate float64           `json:"default_gpu_rate"` // for models without a rate
+	EnergyPerKWh   float64           `json:"energy_per_kwh"`   // 0 disables energy lines
+	Groups         map[string]string `json:"groups"`           // user -> group
+	Projects       map[string]string `json:"projects"`         // group -> project code
+}
+
+// LoadConfig reads a chargeback config file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if cfg.Currency == "" {
+		cfg.Currency = "USD"
+	}
+	return cfg, nil
+}
+
+// GPURate returns the hourly rate for a GPU model.
+func (c Config) GPURate(model string) float64 {
+	lm := strings.ToLower(model)
+	for _, r := range c.GPURates {
+		if strings.Contains(lm, strings.ToLower(r.Match)) {
+			return r.PerHour
+		}
+	}
+	return c.DefaultGPURate
+}
+
+// Line is one priced item of an invoice.
+type Line struct {
+	User        string
+	Description string
+	Quantity    float64
+	Unit        string // "GPU-h" or "kWh"
+	Rate        float64
+	Amount      float64
+}
+
+// Invoice is the monthly bill of one group.
+type Invoice struct {
+	Group    string
+	Project  string
+	Period   string // YYYY-MM
+	From     time.Time
+	To       time.Time
+	Currency string
+	Lines    []Line
+	Total    float64
+}
+
+// MonthRange returns the first instant of month and of the following month.
+func MonthRange(month time.Time) (time.Time, time.Time) {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+	return from, from.AddDate(0, 1, 0)
+}
+
+// Generate prices the usage of the month containing month and returns one
+// invoice per group, sorted by group name. The result only depends on the
+// stored history and cfg; maxGap is accounting.Options.MaxGap.
+func Generate(db store.Store, cfg Config, month time.Time, maxGap time.Duration) ([]Invoice, error) {
+	from, to := MonthRange(month)
+	rep, err := accounting.Compute(db, from, to, accounting.Options{GroupBy: accounting.ByUser, MaxGap: maxGap})
+	if err != nil {
+		return nil, err
+	}
+	return Build(rep, cfg), nil
+}
+
+// Build turns a per-user accounting report into invoices.
+func Build(rep accounting.Report, cfg Config) []Invoice {
+	invoices := make(map[string]*Invoice)
+	for _, u := range rep.Rows {
+		group, ok := cfg.Groups[u.Key]
+		if !ok {
+			group = Unassigned
+		}
+		inv, ok := invoices[group]
+		if !ok {
+			inv = &Invoice{
+				Group:    group,
+				Project:  cfg.Projects[group],
+				Period:   rep.From.Format("2006-01"),
+				From:     rep.From,
+				To:       rep.To,
+				Currency: cfg.Currency,
+			}
+			invoices[group] = inv
+		}
+		models := make([]string, 0, len(u.ByModel))
+		for m := range u.ByModel {
+			models = append(models, m)
+		}
+		sort.Strings(models)
+		for _, m := range models {
+			rate := cfg.GPURate(m)
+			inv.add(Line{User: u.Key, Description: "GPU time — " + m, Quantity: u.ByModel[m], Unit: "GPU-h", Rate: rate})
+		}
+		if cfg.EnergyPerKWh > 0 && u.EnergyKWh > 0 {
+			inv.add(Line{User: u.Key, Description: "Energy", Quantity: u.EnergyKWh, Unit: "kWh", Rate: cfg.EnergyPerKWh})
+		}
+	}
+	out := make([]Invoice, 0, len(invoices))
+	for _, inv := range invoices {
+		sort.SliceStable(inv.Lines, func(i, j int) bool { return inv.Lines[i].User < inv.Lines[j].User })
+		out = append(out, *inv)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Group < out[j].Group })
+	return out
+}
+
+func (inv *Invoice) add(l Line) {
+	l.Amount = round2(l.Quantity * l.Rate)
+	inv.Lines = append(inv.Lines, l)
+	inv.Total = round2(inv.Total + l.Amount)
+}
+
+func round2(v float64) float64 { return math.Round(v*100) / 100 }
@@ -0,0 +1,121 @@
+package detect
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/accounting"
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// interleaved returns n snapshots per host, alternating between the hosts
+// every minute. Each host runs PID 100 on its own GPU; memory grows by
+// growMB per snapshot.
+func interleaved(start time.Time, n int, growMB float64, hosts ...string) []types.Snapshot {
+	var out []types.Snapshot
+	for i := 0; i < n; i++ {
+		for j, h := range hosts {
+			uuid := "GPU-" + h
+			out = append(out, types.Snapshot{
+				TS:    start.Add(time.Duration(i)*time.Minute + time.Duration(j)*time.Second),
+				Host:  types.Host{Hostname: h, MachineID: "m-" + h},
+				GPUs:  []types.GPU{{Index: 0, UUID: uuid, UtilGPU: 0, MemUsedMB: 2048 + float64(i)*growMB, MemTotalMB: 40960}},
+				Procs: []types.GPUProcess{{PID: 100, User: "alice", GPUUUID: uuid, UsedMemMB: 2048 + float64(i)*growMB}},
+			})
+		}
+	}
+	return out
+}
+
+func TestIdleDetectorInterleavedHosts(t *testing.T) {
+	cfg := IdleConfig{MinMemMB: 1024, MaxUtil: 5, MinDuration: 10 * time.Minute, MaxGap: 5 * time.Minute}
+	det := NewIdleDetector(cfg)
+	flagged := make(map[string]bool)
+	for _, s := range interleaved(time.Unix(1e9, 0), 15, 0, "a", "b") {
+		for _, a := range det.Observe(s) {
+			if a.Host != s.Host.Hostname {
+				t.Fatalf("alloc of %s reported for a snapshot of %s", a.Host, s.Host.Hostname)
+			}
+			flagged[a.Host] = true
+		}
+	}
+	if !flagged["a"] || !flagged["b"] {
+		t.Errorf("flagged %v, want both hosts", flagged)
+	}
+}
+
+// TestIdleDetectorSlowInterval checks that samples taken every 10 minutes
+// accumulate idle time when MaxGap follows the interval like accounting,
+// and restart tracking at every sample under the 5 minute default.
+func TestIdleDetectorSlowInterval(t *testing.T) {
+	const interval = 10 * time.Minute
+	var snaps []types.Snapshot
+	for i := 0; i < 5; i++ {
+		snaps = append(snaps, types.Snapshot{
+			TS:    time.Unix(1e9, 0).Add(time.Duration(i) * interval),
+			Host:  types.Host{Hostname: "a"},
+			GPUs:  []types.GPU{{Index: 0, UUID: "GPU-a", MemUsedMB: 2048, MemTotalMB: 40960}},
+			Procs: []types.GPUProcess{{PID: 100, User: "alice", GPUUUID: "GPU-a", UsedMemMB: 2048}},
+		})
+	}
+	run := func(maxGap time.Duration) (flagged []IdleAlloc) {
+		cfg := DefaultIdleConfig
+		cfg.MaxGap = maxGap
+		det := NewIdleDetector(cfg)
+		for _, s := range snaps {
+			flagged = det.Observe(s)
+		}
+		return flagged
+	}
+	got := run(accounting.MaxGapFor(interval))
+	if len(got) != 1 || got[0].Duration() != 40*time.Minute {
+		t.Errorf("MaxGapFor(%s): got %+v, want one allocation idle for 40m", interval, got)
+	}
+	if got := run(DefaultIdleConfig.MaxGap); len(got) != 0 {
+		t.Errorf("default MaxGap: got %+v, want none", got)
+	}
+}
+
+func TestLeakDetectorInterleavedHosts(t *testing.T) {
+	det := NewLeakDetector(LeakConfig{Window: 30 * time.Minute, MinSamples: 5, MinRate: 100, MinR2: 0.8})
+	leaking := make(map[string]bool)
+	for _, s := range interleaved(time.Unix(1e9, 0), 10, 10, "a", "b") { // 600 MB/h
+		for _, g := range det.Observe(s) {
+			if g.Leaking {
+				leaking[g.Host] = true
+			}
+		}
+	}
+	if !leaking["a"] || !leaking["b"] {
+		t.Errorf("leaking %v, want both hosts", leaking)
+	}
+}
+
+func TestFindIdleAllHosts(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	start := time.Unix(1e9, 0)
+	for _, s := range interleaved(start, 15, 0, "a", "b") {
+		if _, err := db.SaveSnapshot(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := IdleConfig{MinMemMB: 1024, MaxUtil: 5, MinDuration: 10 * time.Minute, MaxGap: 5 * time.Minute}
+	eps, err := FindIdle(db, start, start.Add(time.Hour), "", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eps) != 2 {
+		t.Fatalf("got %d episodes, want one per host: %+v", len(eps), eps)
+	}
+	for _, ep := range eps {
+		if ep.Duration() != 14*time.Minute {
+			t.Errorf("%s: episode lasted %s, want 14m", ep.Host, ep.Duration())
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package detect
+
+import (
+	"sort"
+	"time"
+
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// IdleConfig defines when a process counts as squatting a GPU: it holds at
+// least MinMemMB while the GPU's UtilGPU stays at or below MaxUtil for MinDuration.
+type IdleConfig struct {
+	MinMemMB    float64
+	MaxUtil     float64
+	MinDuration time.Duration
+	MaxGap      time.Duration // a longer gap between snapshots restarts tracking
+}
+
+// DefaultIdleConfig flags processes holding 1 GiB on a GPU below 5% for 30 minutes.
+var DefaultIdleConfig = IdleConfig{
+	MinMemMB:    1024,
+	MaxUtil:     5,
+	MinDuration: 30 * time.Minute,
+	MaxGap:      5 * time.Minute,
+}
+
+// IdleAlloc is a process holding GPU memory on an idle GPU.
+type IdleAlloc struct {
+	Host        string
+	User        string
+	PID         int
+	ProcessName string
+	GPUIndex    int
+	GPUUUID     string
+	MemMB       float64
+	Since       time.Time
+	Last        time.Time
+	New         bool // first snapshot in which the allocation crossed MinDuration
+}
+
+// Duration is how long the allocation has been idle.
+func (a IdleAlloc) Duration() time.Duration { return a.Last.Sub(a.Since) }
+
+type procKey struct {
+	uuid string
+	pid  int
+}
+
+// hostProc is a procKey on a host (types.Host.Key), for state kept across
+// the snapshots of several hosts.
+type hostProc struct {
+	host string
+	procKey
+}
+
+type idleState struct {
+	since   time.Time
+	last    time.Time
+	flagged bool
+}
+
+// IdleDetector tracks idle allocations across consecutive snapshots. The
+// snapshots of several hosts may be interleaved; each host is tracked on
+// its own.
+type IdleDetector struct {
+	cfg   IdleConfig
+	state map[string]map[procKey]*idleState // by host key
+}
+
+func NewIdleDetector(cfg IdleConfig) *IdleDetector {
+	if cfg.MaxGap <= 0 {
+		cfg.MaxGap = DefaultIdleConfig.MaxGap
+	}
+	return &IdleDetector{cfg: cfg, state: make(map[string]map[procKey]*idleState)}
+}
+
+// Observe feeds the next snapshot and returns the allocations currently idle
+// for at least MinDuration, longest first. The snapshots of each host must
+// arrive in time order.
+func (d *IdleDetector) Observe(s types.Snapshot) []IdleAlloc {
+	hk := s.Host.Key()
+	state, ok := d.state[hk]
+	if !ok {
+		state = make(map[procKey]*idleState)
+		d.state[hk] = state
+	}
+	gpus := make(map[string]types.GPU, len(s.GPUs))
+	for _, g := range s.GPUs {
+		gpus[g.UUID] = g
+	}
+	seen := make(map[procKey]bool)
+	var out []IdleAlloc
+	for _, p := range s.Procs {
+		g, ok := gpus[p.GPUUUID]
+		if !ok || p.UsedMemMB < d.cfg.MinMemMB || g.UtilGPU > d.cfg.MaxUtil {
+			continue
+		}
+		k := procKey{p.GPUUUID, p.PID}
+		seen[k] = true
+		st, ok := state[k]
+		if !ok || s.TS.Sub(st.last) > d.cfg.MaxGap {
+			st = &idleState{since: s.TS}
+			state[k] = st
+		}
+		st.last = s.TS
+		if st.last.Sub(st.since) < d.cfg.MinDuration {
+			continue
+		}
+		a := IdleAlloc{
+			Host:        s.Host.Hostname,
+			User:        p.User,
+			PID:         p.PID,
+			ProcessName: p.ProcessName,
+			GPUIndex:    g.Index,
+			GPUUUID:     p.GPUUUID,
+			MemMB:       p.UsedMemMB,
+			Since:       st.since,
+			Last:        st.last,
+			New:         !st.flagged,
+		}
+		st.flagged = true
+		out = append(out, a)
+	}
+	for k := range state {
+		if !seen[k] {
+			delete(state, k)
+		}
+	}
+	if len(state) == 0 {
+		delete(d.state, hk)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.Before(out[j].Since) })
+	return out
+}
+
+// IdleEpisode is one finished or ongoing idle allocation found in history.
+type IdleEpisode struct {
+	IdleAlloc
+	PeakMemMB float64
+}
+
+// FindIdle replays stored snapshots of host ("" = all) in [from, to) and
+// returns every idle allocation episode that lasted at least cfg.MinDuration,
+// oldest first.
+func FindIdle(db store.Store, from, to time.Time, host string, cfg IdleConfig) ([]IdleEpisode, error) {
+	det := NewIdleDetector(cfg)
+	open := make(map[hostProc]*IdleEpisode)
+	var done []IdleEpisode
+	err := db.WalkRange(from, to, host, func(s types.Snapshot) error {
+		hk := s.Host.Key()
+		active := make(map[hostProc]bool)
+		for _, a := range det.Observe(s) {
+			k := hostProc{hk, procKey{a.GPUUUID, a.PID}}
+			active[k] = true
+			ep, ok := open[k]
+			if !ok || !ep.Since.Equal(a.Since) {
+				if ok {
+					done = append(done, *ep)
+				}
+				ep = &IdleEpisode{}
+				open[k] = ep
+			}
+			ep.IdleAlloc = a
+			if a.MemMB > ep.PeakMemMB {
+				ep.PeakMemMB = a.MemMB
+			}
+		}
+		for k, ep := range open {
+			if k.host == hk && !active[k] {
+				done = append(done, *ep)
+				delete(open, k)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, ep := range open {
+		done = append(done, *ep)
+	}
+	sort.Slice(done, func(i, j int) bool { return done[i].Since.Before(done[j].Since) })
+	return done, nil
+}
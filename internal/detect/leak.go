@@ -0,0 +1,159 @@
+package detect
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// LeakConfig controls per-process memory trend analysis.
+type LeakConfig struct {
+	Window     time.Duration // sliding window used for the regression
+	MinSamples int           // points required before a trend is reported
+	MinRate    float64       // MB per hour of sustained growth that counts as a leak
+	MinR2      float64       // goodness of fit required to call growth sustained
+}
+
+// DefaultLeakConfig flags growth of at least 100 MB/h over a 30 minute window.
+var DefaultLeakConfig = LeakConfig{
+	Window:     30 * time.Minute,
+	MinSamples: 10,
+	MinRate:    100,
+	MinR2:      0.8,
+}
+
+// Growth is the memory trend of one process on one GPU.
+type Growth struct {
+	Host        string
+	User        string
+	PID         int
+	ProcessName string
+	GPUIndex    int
+	GPUUUID     string
+	MemMB       float64
+	RateMBPerH  float64       // regression slope
+	R2          float64       // coefficient of determination of the fit
+	TimeToOOM   time.Duration // at the current rate until the GPU is full; 0 if not growing
+	Leaking     bool
+	New         bool // first snapshot in which Leaking became true
+}
+
+type point struct {
+	ts  time.Time
+	mem float64
+}
+
+type series struct {
+	points  []point
+	leaking bool
+}
+
+// LeakDetector fits a line to each process's UsedMemMB over a sliding
+// window, tracking the processes of each host on their own.
+type LeakDetector struct {
+	cfg    LeakConfig
+	series map[string]map[procKey]*series // by host key
+}
+
+func NewLeakDetector(cfg LeakConfig) *LeakDetector {
+	if cfg.MinSamples < 3 {
+		cfg.MinSamples = 3
+	}
+	return &LeakDetector{cfg: cfg, series: make(map[string]map[procKey]*series)}
+}
+
+// Observe feeds the next snapshot and returns the trend of every process
+// with enough samples in the window, fastest growing first. The snapshots
+// of each host must arrive in time order.
+func (d *LeakDetector) Observe(s types.Snapshot) []Growth {
+	hk := s.Host.Key()
+	procs, ok := d.series[hk]
+	if !ok {
+		procs = make(map[procKey]*series)
+		d.series[hk] = procs
+	}
+	gpus := make(map[string]types.GPU, len(s.GPUs))
+	for _, g := range s.GPUs {
+		gpus[g.UUID] = g
+	}
+	seen := make(map[procKey]bool)
+	var out []Growth
+	for _, p := range s.Procs {
+		k := procKey{p.GPUUUID, p.PID}
+		seen[k] = true
+		sr, ok := procs[k]
+		if !ok {
+			sr = &series{}
+			procs[k] = sr
+		}
+		sr.points = append(sr.points, point{s.TS, p.UsedMemMB})
+		cut := 0
+		for cut < len(sr.points) && s.TS.Sub(sr.points[cut].ts) > d.cfg.Window {
+			cut++
+		}
+		sr.points = sr.points[cut:]
+		if len(sr.points) < d.cfg.MinSamples {
+			continue
+		}
+
+		slope, r2 := fit(sr.points)
+		g := gpus[p.GPUUUID]
+		gr := Growth{
+			Host:        s.Host.Hostname,
+			User:        p.User,
+			PID:         p.PID,
+			ProcessName: p.ProcessName,
+			GPUIndex:    g.Index,
+			GPUUUID:     p.GPUUUID,
+			MemMB:       p.UsedMemMB,
+			RateMBPerH:  slope,
+			R2:          r2,
+		}
+		if slope > 0 && g.MemTotalMB > 0 {
+			free := math.Max(g.MemTotalMB-g.MemUsedMB, 0)
+			gr.TimeToOOM = time.Duration(free / slope * float64(time.Hour))
+		}
+		gr.Leaking = slope >= d.cfg.MinRate && r2 >= d.cfg.MinR2
+		gr.New = gr.Leaking && !sr.leaking
+		sr.leaking = gr.Leaking
+		out = append(out, gr)
+	}
+	for k := range procs {
+		if !seen[k] {
+			delete(procs, k)
+		}
+	}
+	if len(procs) == 0 {
+		delete(d.series, hk)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].RateMBPerH > out[j].RateMBPerH })
+	return out
+}
+
+// fit returns the least-squares slope in MB/h and its R².
+func fit(pts []point) (float64, float64) {
+	n := float64(len(pts))
+	t0 := pts[0].ts
+	var sx, sy, sxx, sxy, syy float64
+	for _, p := range pts {
+		x := p.ts.Sub(t0).Hours()
+		sx += x
+		sy += p.mem
+		sxx += x * x
+		sxy += x * p.mem
+		syy += p.mem * p.mem
+	}
+	vx := n*sxx - sx*sx
+	vy := n*syy - sy*sy
+	if vx == 0 {
+		return 0, 0
+	}
+	slope := (n*sxy - sx*sy) / vx
+	if vy == 0 {
+		return slope, 0 // flat line
+	}
+	r := (n*sxy - sx*sy) / math.Sqrt(vx*vy)
+	return slope, r * r
+}
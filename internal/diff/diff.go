@@ -0,0 +1,217 @@
+// Package diff compares two snapshots to show what changed between them.
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// Delta is a value in both snapshots and its change.
+type Delta struct {
+	Before float64
+	After  float64
+	Change float64
+}
+
+func delta(before, after float64) Delta {
+	return Delta{Before: before, After: after, Change: after - before}
+}
+
+// GPUChange holds the metric deltas of one GPU present in either snapshot.
+type GPUChange struct {
+	Index   int
+	UUID    string
+	Name    string
+	Status  string // "", "added" or "removed"
+	UtilGPU Delta
+	UtilMem Delta
+	MemMB   Delta
+	TempC   Delta
+	PowerW  Delta
+}
+
+// ProcChange is one process on one GPU.
+type ProcChange struct {
+	PID         int
+	ProcessName string
+	User        string
+	GPUIndex    int
+	GPUUUID     string
+	MemMB       Delta
+}
+
+// UserChange is the GPU memory and process count of one user.
+type UserChange struct {
+	User   string
+	Status string // "", "added" or "removed"
+	MemMB  Delta
+	Procs  [2]int // before, after
+}
+
+// Diff is the change from snapshot A to snapshot B.
+type Diff struct {
+	FromID  int64
+	ToID    int64
+	From    time.Time
+	To      time.Time
+	Elapsed time.Duration
+	Host    string
+	GPUs    []GPUChange
+	Started []ProcChange // in B only
+	Ended   []ProcChange // in A only
+	Changed []ProcChange // in both, with different memory
+	Users   []UserChange // every user in either snapshot, largest change first
+}
+
+type procKey struct {
+	uuid string
+	pid  int
+}
+
+// ErrHostMismatch is returned by Compare for snapshots of different hosts,
+// whose GPUs and processes have nothing in common.
+var ErrHostMismatch = errors.New("snapshots are from different hosts")
+
+// Compare returns the changes from a to b, which must be snapshots of the
+// same host (types.Host.Key).
+func Compare(a, b types.Snapshot) (Diff, error) {
+	if a.Host.Key() != b.Host.Key() {
+		return Diff{}, fmt.Errorf("%w: #%d is from %s, #%d from %s", ErrHostMismatch, a.ID, hostName(a.Host), b.ID, hostName(b.Host))
+	}
+	d := Diff{FromID: a.ID, ToID: b.ID, From: a.TS, To: b.TS, Elapsed: b.TS.Sub(a.TS), Host: b.Host.Hostname}
+	if d.Host == "" {
+		d.Host = a.Host.Hostname
+	}
+
+	gpuIndex := make(map[string]int)
+	before := make(map[string]types.GPU, len(a.GPUs))
+	for _, g := range a.GPUs {
+		before[g.UUID] = g
+		gpuIndex[g.UUID] = g.Index
+	}
+	for _, g := range b.GPUs {
+		gpuIndex[g.UUID] = g.Index
+		p, ok := before[g.UUID]
+		c := gpuChange(p, g)
+		if !ok {
+			c.Status = "added"
+		}
+		delete(before, g.UUID)
+		d.GPUs = append(d.GPUs, c)
+	}
+	for _, p := range before {
+		c := gpuChange(p, types.GPU{})
+		c.Index, c.UUID, c.Name, c.Status = p.Index, p.UUID, p.Name, "removed"
+		d.GPUs = append(d.GPUs, c)
+	}
+	sort.Slice(d.GPUs, func(i, j int) bool { return d.GPUs[i].Index < d.GPUs[j].Index })
+
+	procs := make(map[procKey]types.GPUProcess, len(a.Procs))
+	for _, p := range a.Procs {
+		procs[procKey{p.GPUUUID, p.PID}] = p
+	}
+	users := make(map[string]*UserChange)
+	user := func(name string) *UserChange {
+		u, ok := users[name]
+		if !ok {
+			u = &UserChange{User: name}
+			users[name] = u
+		}
+		return u
+	}
+	for _, p := range a.Procs {
+		u := user(p.User)
+		u.MemMB.Before += p.UsedMemMB
+		u.Procs[0]++
+	}
+	for _, p := range b.Procs {
+		u := user(p.User)
+		u.MemMB.After += p.UsedMemMB
+		u.Procs[1]++
+
+		k := procKey{p.GPUUUID, p.PID}
+		prev, ok := procs[k]
+		if !ok {
+			d.Started = append(d.Started, procChange(p, gpuIndex, 0, p.UsedMemMB))
+			continue
+		}
+		delete(procs, k)
+		if prev.UsedMemMB != p.UsedMemMB {
+			d.Changed = append(d.Changed, procChange(p, gpuIndex, prev.UsedMemMB, p.UsedMemMB))
+		}
+	}
+	for _, p := range procs {
+		d.Ended = append(d.Ended, procChange(p, gpuIndex, p.UsedMemMB, 0))
+	}
+	byMem := func(s []ProcChange) {
+		sort.Slice(s, func(i, j int) bool {
+			ci, cj := math.Abs(s[i].MemMB.Change), math.Abs(s[j].MemMB.Change)
+			if ci != cj {
+				return ci > cj
+			}
+			return s[i].PID < s[j].PID
+		})
+	}
+	byMem(d.Started)
+	byMem(d.Ended)
+	byMem(d.Changed)
+
+	for _, u := range users {
+		u.MemMB.Change = u.MemMB.After - u.MemMB.Before
+		switch {
+		case u.Procs[0] == 0:
+			u.Status = "added"
+		case u.Procs[1] == 0:
+			u.Status = "removed"
+		}
+		d.Users = append(d.Users, *u)
+	}
+	sort.Slice(d.Users, func(i, j int) bool {
+		ci, cj := math.Abs(d.Users[i].MemMB.Change), math.Abs(d.Users[j].MemMB.Change)
+		if ci != cj {
+			return ci > cj
+		}
+		return d.Users[i].User < d.Users[j].User
+	})
+	return d, nil
+}
+
+// hostName names h in errors; snapshots taken before host identity have none.
+func hostName(h types.Host) string {
+	if h.Hostname != "" {
+		return h.Hostname
+	}
+	if h.MachineID != "" {
+		return h.MachineID
+	}
+	return "an unknown host"
+}
+
+func gpuChange(a, b types.GPU) GPUChange {
+	return GPUChange{
+		Index:   b.Index,
+		UUID:    b.UUID,
+		Name:    b.Name,
+		UtilGPU: delta(a.UtilGPU, b.UtilGPU),
+		UtilMem: delta(a.UtilMem, b.UtilMem),
+		MemMB:   delta(a.MemUsedMB, b.MemUsedMB),
+		TempC:   delta(a.TempC, b.TempC),
+		PowerW:  delta(a.PowerDrawW, b.PowerDrawW),
+	}
+}
+
+func procChange(p types.GPUProcess, gpuIndex map[string]int, before, after float64) ProcChange {
+	return ProcChange{
+		PID:         p.PID,
+		ProcessName: p.ProcessName,
+		User:        p.User,
+		GPUIndex:    gpuIndex[p.GPUUUID],
+		GPUUUID:     p.GPUUUID,
+		MemMB:       delta(before, after),
+	}
+}
@@ -0,0 +1,44 @@
+package diff
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+func snapshot(id int64, host types.Host, ts time.Time, memMB float64) types.Snapshot {
+	return types.Snapshot{
+		ID:    id,
+		TS:    ts,
+		Host:  host,
+		GPUs:  []types.GPU{{Index: 0, UUID: "GPU-" + host.Key(), MemUsedMB: memMB}},
+		Procs: []types.GPUProcess{{PID: 100, User: "alice", GPUUUID: "GPU-" + host.Key(), UsedMemMB: memMB}},
+	}
+}
+
+func TestCompareTwoHosts(t *testing.T) {
+	t0 := time.Unix(1e9, 0)
+	alpha := types.Host{Hostname: "alpha", MachineID: "m-alpha"}
+	beta := types.Host{Hostname: "beta", MachineID: "m-beta"}
+
+	d, err := Compare(snapshot(1, alpha, t0, 1000), snapshot(3, alpha, t0.Add(time.Minute), 1500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Host != "alpha" || len(d.GPUs) != 1 || d.GPUs[0].MemMB.Change != 500 || len(d.Changed) != 1 {
+		t.Errorf("same host: %+v", d)
+	}
+
+	_, err = Compare(snapshot(1, alpha, t0, 1000), snapshot(2, beta, t0.Add(time.Second), 1000))
+	if !errors.Is(err, ErrHostMismatch) {
+		t.Errorf("alpha → beta: %v, want ErrHostMismatch", err)
+	}
+
+	// Same hostname on another machine is another host too.
+	clone := types.Host{Hostname: "alpha", MachineID: "m-alpha-2"}
+	if _, err := Compare(snapshot(1, alpha, t0, 1000), snapshot(4, clone, t0, 1000)); !errors.Is(err, ErrHostMismatch) {
+		t.Errorf("alpha → alpha on another machine: %v, want ErrHostMismatch", err)
+	}
+}
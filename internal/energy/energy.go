@@ -0,0 +1,249 @@
+package energy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpuwatch/internal/accounting"
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// Intensity is a carbon-intensity factor in grams CO2e per kWh, either static
+// or varying by time of day.
+type Intensity struct {
+	slots []slot         // sorted by start minute; empty means zero
+	loc   *time.Location // zone of the time-of-day table; nil means t's own
+}
+
+type slot struct {
+	minute int // minutes since midnight in loc
+	grams  float64
+}
+
+// StaticIntensity returns a constant factor.
+func StaticIntensity(gramsPerKWh float64) Intensity {
+	return Intensity{slots: []slot{{0, gramsPerKWh}}}
+}
+
+// ParseIntensity accepts either a number (static g/kWh) or the path of a
+// time-of-day table with "HH:MM,grams" lines; each entry applies until the
+// next one and the last wraps around midnight.
+func ParseIntensity(spec string) (Intensity, error) {
+	if spec == "" {
+		return Intensity{}, nil
+	}
+	if v, err := strconv.ParseFloat(spec, 64); err == nil {
+		return StaticIntensity(v), nil
+	}
+	f, err := os.Open(spec)
+	if err != nil {
+		return Intensity{}, err
+	}
+	defer f.Close()
+	var in Intensity
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ",")
+		if len(parts) != 2 {
+			return Intensity{}, fmt.Errorf("%s:%d: expected HH:MM,grams", spec, n)
+		}
+		t, err := time.Parse("15:04", strings.TrimSpace(parts[0]))
+		if err != nil {
+			return Intensity{}, fmt.Errorf("%s:%d: %v", spec, n, err)
+		}
+		g, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return Intensity{}, fmt.Errorf("%s:%d: %v", spec, n, err)
+		}
+		in.slots = append(in.slots, slot{t.Hour()*60 + t.Minute(), g})
+	}
+	if err := s.Err(); err != nil {
+		return Intensity{}, err
+	}
+	sort.Slice(in.slots, func(i, j int) bool { return in.slots[i].minute < in.slots[j].minute })
+	return in, nil
+}
+
+// In returns the factor with its time-of-day table read in loc.
+func (in Intensity) In(loc *time.Location) Intensity {
+	in.loc = loc
+	return in
+}
+
+// Enabled reports whether a factor was configured.
+func (in Intensity) Enabled() bool { return len(in.slots) > 0 }
+
+// At returns the factor in g/kWh that applies at t.
+func (in Intensity) At(t time.Time) float64 {
+	if len(in.slots) == 0 {
+		return 0
+	}
+	if in.loc != nil {
+		t = t.In(in.loc)
+	}
+	m := t.Hour()*60 + t.Minute()
+	g := in.slots[len(in.slots)-1].grams
+	for _, s := range in.slots {
+		if s.minute > m {
+			break
+		}
+		g = s.grams
+	}
+	return g
+}
+
+// Row is the energy of one GPU, user or host over the period.
+type Row struct {
+	Key       string
+	EnergyKWh float64
+	CarbonKg  float64
+}
+
+// Report holds energy per host, per GPU and per user.
+type Report struct {
+	From         time.Time
+	To           time.Time
+	Samples      int
+	Hosts        []Row
+	GPUs         []Row
+	Users        []Row
+	Unattributed Row // energy of GPUs with no running processes
+}
+
+// Total sums the energy of all hosts.
+func (r Report) Total() Row {
+	t := Row{Key: "TOTAL"}
+	for _, h := range r.Hosts {
+		t.EnergyKWh += h.EnergyKWh
+		t.CarbonKg += h.CarbonKg
+	}
+	return t
+}
+
+// Accumulator integrates PowerDrawW over consecutive snapshots with an
+// accounting.Integrator, so energy covers the same time as GPU-hours.
+type Accumulator struct {
+	carbon Intensity
+	win    *accounting.Integrator
+	hosts  map[string]*Row
+	idle   Row
+	gpus   map[string]*Row
+	users  map[string]*Row
+}
+
+// NewAccumulator returns an Accumulator; maxGap <= 0 means
+// accounting.DefaultMaxGap.
+func NewAccumulator(carbon Intensity, maxGap time.Duration) *Accumulator {
+	a := &Accumulator{
+		carbon: carbon,
+		hosts:  make(map[string]*Row),
+		idle:   Row{Key: "idle"},
+		gpus:   make(map[string]*Row),
+		users:  make(map[string]*Row),
+	}
+	a.win = accounting.NewIntegrator(maxGap, a.integrate)
+	return a
+}
+
+// Add feeds the next snapshot; snapshots of each host must arrive in time order.
+func (a *Accumulator) Add(s types.Snapshot) { a.win.Add(s) }
+
+// Finish accounts the last sample of each host up to end and returns the report.
+func (a *Accumulator) Finish(from, end time.Time) Report {
+	a.win.Finish(end)
+	return Report{
+		From:         from,
+		To:           end,
+		Samples:      a.win.Samples(),
+		Hosts:        sorted(a.hosts),
+		GPUs:         sorted(a.gpus),
+		Users:        sorted(a.users),
+		Unattributed: a.idle,
+	}
+}
+
+func (a *Accumulator) integrate(s types.Snapshot, dt time.Duration) {
+	hours := dt.Hours()
+	kgPerKWh := a.carbon.At(s.TS) / 1000
+	procs := make(map[string][]types.GPUProcess)
+	for _, p := range s.Procs {
+		procs[p.GPUUUID] = append(procs[p.GPUUUID], p)
+	}
+	host := s.Host.Hostname
+	if host == "" {
+		host = "unknown"
+	}
+	for _, g := range s.GPUs {
+		kwh := g.PowerDrawW / 1000 * hours
+		add(row(a.hosts, host), kwh, kgPerKWh)
+		add(row(a.gpus, fmt.Sprintf("%s/GPU %d (%s)", host, g.Index, g.Name)), kwh, kgPerKWh)
+
+		ps := procs[g.UUID]
+		if len(ps) == 0 {
+			add(&a.idle, kwh, kgPerKWh)
+			continue
+		}
+		var totalMem float64
+		for _, p := range ps {
+			totalMem += p.UsedMemMB
+		}
+		for _, p := range ps {
+			share := 1 / float64(len(ps))
+			if totalMem > 0 {
+				share = p.UsedMemMB / totalMem
+			}
+			add(row(a.users, p.User), share*kwh, kgPerKWh)
+		}
+	}
+}
+
+func add(r *Row, kwh, kgPerKWh float64) {
+	r.EnergyKWh += kwh
+	r.CarbonKg += kwh * kgPerKWh
+}
+
+func row(m map[string]*Row, key string) *Row {
+	r, ok := m[key]
+	if !ok {
+		r = &Row{Key: key}
+		m[key] = r
+	}
+	return r
+}
+
+func sorted(m map[string]*Row) []Row {
+	out := make([]Row, 0, len(m))
+	for _, r := range m {
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].EnergyKWh != out[j].EnergyKWh {
+			return out[i].EnergyKWh > out[j].EnergyKWh
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// Compute integrates all stored snapshots of host ("" = all) with from <= ts < to.
+// maxGap is passed to NewAccumulator.
+func Compute(db store.Store, from, to time.Time, host string, carbon Intensity, maxGap time.Duration) (Report, error) {
+	acc := NewAccumulator(carbon, maxGap)
+	if err := db.WalkRange(from, to, host, func(s types.Snapshot) error {
+		acc.Add(s)
+		return nil
+	}); err != nil {
+		return Report{}, err
+	}
+	return acc.Finish(from, to), nil
+}
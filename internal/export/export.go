@@ -0,0 +1,199 @@
+// Package export streams stored history as flat rows for analysis tools.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpuwatch/internal/accounting"
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// Row is one process on one GPU in one snapshot. GPUs without processes get
+// a single row with PID 0. Column names are the same in every format.
+//
+// IntervalS is the time the snapshot covers, as in accounting, and EnergyWh
+// the GPU's energy over it; a process row carries its memory share of that
+// energy, so summing EnergyWh over rows never counts a GPU twice.
+type Row struct {
+	TS          int64   `json:"-" parquet:"ts,timestamp(nanosecond)"`
+	Time        string  `json:"ts" parquet:"-"`
+	SnapshotID  int64   `json:"snapshot_id" parquet:"snapshot_id"`
+	Host        string  `json:"host" parquet:"host,dict"`
+	GPUIndex    int32   `json:"gpu_index" parquet:"gpu_index"`
+	GPUUUID     string  `json:"gpu_uuid" parquet:"gpu_uuid,dict"`
+	GPUName     string  `json:"gpu_name" parquet:"gpu_name,dict"`
+	UtilGPU     float64 `json:"util_gpu" parquet:"util_gpu"`
+	UtilMem     float64 `json:"util_mem" parquet:"util_mem"`
+	MemUsedMB   float64 `json:"mem_used_mb" parquet:"mem_used_mb"`
+	MemTotalMB  float64 `json:"mem_total_mb" parquet:"mem_total_mb"`
+	TempC       float64 `json:"temp_c" parquet:"temp_c"`
+	PowerW      float64 `json:"power_w" parquet:"power_w"`
+	PID         int32   `json:"pid" parquet:"pid"`
+	ProcessName string  `json:"process_name" parquet:"process_name,dict"`
+	User        string  `json:"user" parquet:"user,dict"`
+	ProcMemMB   float64 `json:"proc_mem_mb" parquet:"proc_mem_mb"`
+	IntervalS   float64 `json:"interval_s" parquet:"interval_s"`
+	EnergyWh    float64 `json:"energy_wh" parquet:"energy_wh"`
+}
+
+var columns = []string{"ts", "snapshot_id", "host", "gpu_index", "gpu_uuid", "gpu_name", "util_gpu", "util_mem",
+	"mem_used_mb", "mem_total_mb", "temp_c", "power_w", "pid", "process_name", "user", "proc_mem_mb", "interval_s", "energy_wh"}
+
+// Filter selects rows; GPU -1 and User "" match everything.
+type Filter struct {
+	Host string
+	GPU  int
+	User string
+}
+
+// Rows flattens s, covering dt, into the rows matching f. Timestamps are
+// rendered in loc.
+func Rows(s types.Snapshot, dt time.Duration, f Filter, loc *time.Location) []Row {
+	var out []Row
+	for _, g := range s.GPUs {
+		if f.GPU >= 0 && g.Index != f.GPU {
+			continue
+		}
+		base := Row{
+			TS:         s.TS.UnixNano(),
+			Time:       s.TS.In(loc).Format(time.RFC3339Nano),
+			SnapshotID: s.ID,
+			Host:       s.Host.Hostname,
+			GPUIndex:   int32(g.Index),
+			GPUUUID:    g.UUID,
+			GPUName:    g.Name,
+			UtilGPU:    g.UtilGPU,
+			UtilMem:    g.UtilMem,
+			MemUsedMB:  g.MemUsedMB,
+			MemTotalMB: g.MemTotalMB,
+			TempC:      g.TempC,
+			PowerW:     g.PowerDrawW,
+			IntervalS:  dt.Seconds(),
+			EnergyWh:   g.PowerDrawW * dt.Hours(),
+		}
+		var procs []types.GPUProcess
+		var totalMem float64
+		for _, p := range s.Procs {
+			if p.GPUUUID == g.UUID {
+				procs = append(procs, p)
+				totalMem += p.UsedMemMB
+			}
+		}
+		for _, p := range procs {
+			if f.User != "" && !strings.EqualFold(p.User, f.User) {
+				continue
+			}
+			share := 1 / float64(len(procs))
+			if totalMem > 0 {
+				share = p.UsedMemMB / totalMem
+			}
+			r := base
+			r.PID, r.ProcessName, r.User, r.ProcMemMB = int32(p.PID), p.ProcessName, p.User, p.UsedMemMB
+			r.EnergyWh *= share
+			out = append(out, r)
+		}
+		if len(procs) == 0 && f.User == "" {
+			out = append(out, base)
+		}
+	}
+	return out
+}
+
+// Writer encodes rows in one format. Close flushes buffered output but does
+// not close the underlying io.Writer.
+type Writer interface {
+	Write(rows []Row) error
+	Close() error
+}
+
+// Formats lists the names accepted by NewWriter.
+var Formats = []string{"csv", "ndjson", "parquet"}
+
+// NewWriter returns a Writer for format: csv, ndjson (or jsonl) or parquet.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return nil, err
+		}
+		return &csvWriter{cw}, nil
+	case "ndjson", "jsonl", "json":
+		return &ndjsonWriter{json.NewEncoder(w)}, nil
+	case "parquet":
+		return newParquetWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unknown export format: %s (supported: %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// Range streams every snapshot in [from, to) through w and returns the
+// number of rows written. Each snapshot is held until the next one from its
+// host arrives, so intervals match accounting with the same maxGap (<= 0
+// means accounting.DefaultMaxGap); memory stays one snapshot per host.
+func Range(db store.Store, from, to time.Time, f Filter, loc *time.Location, maxGap time.Duration, w Writer) (int, error) {
+	n := 0
+	var werr error
+	win := accounting.NewIntegrator(maxGap, func(s types.Snapshot, dt time.Duration) {
+		if werr != nil {
+			return
+		}
+		rows := Rows(s, dt, f, loc)
+		n += len(rows)
+		werr = w.Write(rows)
+	})
+	err := db.WalkRange(from, to, f.Host, func(s types.Snapshot) error {
+		win.Add(s)
+		return werr
+	})
+	if err != nil {
+		return n, err
+	}
+	win.Finish(to)
+	return n, werr
+}
+
+type csvWriter struct{ w *csv.Writer }
+
+func (c *csvWriter) Write(rows []Row) error {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, r := range rows {
+		pid := ""
+		if r.PID != 0 {
+			pid = strconv.Itoa(int(r.PID))
+		}
+		if err := c.w.Write([]string{
+			r.Time, strconv.FormatInt(r.SnapshotID, 10), r.Host, strconv.Itoa(int(r.GPUIndex)), r.GPUUUID, r.GPUName,
+			f(r.UtilGPU), f(r.UtilMem), f(r.MemUsedMB), f(r.MemTotalMB), f(r.TempC), f(r.PowerW),
+			pid, r.ProcessName, r.User, f(r.ProcMemMB), f(r.IntervalS), f(r.EnergyWh),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct{ enc *json.Encoder }
+
+func (j *ndjsonWriter) Write(rows []Row) error {
+	for _, r := range rows {
+		if err := j.enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *ndjsonWriter) Close() error { return nil }
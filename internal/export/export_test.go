@@ -0,0 +1,145 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+func testStore(t *testing.T, t0 time.Time) store.Store {
+	t.Helper()
+	db, err := store.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for i := 0; i < 3; i++ {
+		s := types.Snapshot{
+			TS:   t0.Add(time.Duration(i) * time.Minute),
+			Host: types.Host{Hostname: "node1"},
+			GPUs: []types.GPU{
+				{Index: 0, UUID: "GPU-0", Name: "A100", PowerDrawW: 300},
+				{Index: 1, UUID: "GPU-1", Name: "A100", PowerDrawW: 60},
+			},
+			Procs: []types.GPUProcess{
+				{PID: 101, ProcessName: "python", UsedMemMB: 2000, GPUUUID: "GPU-0", User: "alice"},
+				{PID: 202, ProcessName: "train", UsedMemMB: 1000, GPUUUID: "GPU-0", User: "bob"},
+			},
+		}
+		if _, err := db.SaveSnapshot(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestRangeEnergy(t *testing.T) {
+	t0 := time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+	db := testStore(t, t0)
+
+	var buf bytes.Buffer
+	w, _ := NewWriter("ndjson", &buf)
+	n, err := Range(db, t0, t0.Add(time.Hour), Filter{GPU: -1}, time.UTC, 0, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 9 {
+		t.Fatalf("rows = %d, want 9", n)
+	}
+	var total float64
+	byUser := map[string]float64{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r Row
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		// The last snapshot covers no more than the previous interval.
+		if r.IntervalS != 60 {
+			t.Errorf("%s pid %d: interval_s = %v, want 60", r.Time, r.PID, r.IntervalS)
+		}
+		total += r.EnergyWh
+		byUser[r.User] += r.EnergyWh
+	}
+	// 3 samples of one minute at 300 W + 60 W.
+	if !near(total, 3*(5+1)) {
+		t.Errorf("total energy_wh = %v, want 18", total)
+	}
+	if !near(byUser["alice"], 10) || !near(byUser["bob"], 5) || !near(byUser[""], 3) {
+		t.Errorf("energy by user = %v, want alice 10, bob 5, idle GPU 3", byUser)
+	}
+}
+
+func TestRangeEnergyFilteredCSV(t *testing.T) {
+	t0 := time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+	db := testStore(t, t0)
+
+	var buf bytes.Buffer
+	w, _ := NewWriter("csv", &buf)
+	// A gap longer than maxGap is capped, and the user filter keeps alice's
+	// share of the GPU rather than the whole GPU.
+	if _, err := Range(db, t0, t0.Add(time.Hour), Filter{GPU: -1, User: "alice"}, time.UTC, 30*time.Second, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 4 {
+		t.Fatalf("got %d records, want header and 3 rows", len(recs))
+	}
+	head := recs[0]
+	if head[len(head)-2] != "interval_s" || head[len(head)-1] != "energy_wh" {
+		t.Fatalf("header = %v", head)
+	}
+	for _, rec := range recs[1:] {
+		if rec[len(rec)-2] != "30" {
+			t.Errorf("interval_s = %s, want 30", rec[len(rec)-2])
+		}
+		// 300 W for 30 s, 2/3 of the GPU's memory.
+		if wh, err := strconv.ParseFloat(rec[len(rec)-1], 64); err != nil || !near(wh, 300*30.0/3600*2/3) {
+			t.Errorf("energy_wh = %s, want 1.67", rec[len(rec)-1])
+		}
+	}
+}
+
+func TestRangeEnergyParquet(t *testing.T) {
+	t0 := time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+	db := testStore(t, t0)
+
+	var buf bytes.Buffer
+	w, _ := NewWriter("parquet", &buf)
+	if _, err := Range(db, t0, t0.Add(time.Hour), Filter{GPU: 1}, time.UTC, 0, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := parquet.Read[Row](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+	for _, r := range rows {
+		if r.IntervalS != 60 || !near(r.EnergyWh, 1) {
+			t.Errorf("interval_s = %v, energy_wh = %v, want 60 and 1", r.IntervalS, r.EnergyWh)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package export
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRowGroup bounds the rows buffered before a row group is written, so
+// long ranges export in constant memory.
+const parquetRowGroup = 64 * 1024
+
+type parquetWriter struct {
+	w       *parquet.GenericWriter[Row]
+	pending int
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{w: parquet.NewGenericWriter[Row](w, parquet.Compression(&parquet.Zstd))}
+}
+
+func (p *parquetWriter) Write(rows []Row) error {
+	if _, err := p.w.Write(rows); err != nil {
+		return err
+	}
+	p.pending += len(rows)
+	if p.pending >= parquetRowGroup {
+		p.pending = 0
+		return p.w.Flush()
+	}
+	return nil
+}
+
+func (p *parquetWriter) Close() error { return p.w.Close() }
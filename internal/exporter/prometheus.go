@@ -0,0 +1,176 @@
+// Package exporter renders snapshots in the Prometheus text exposition format.
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gpuwatch/internal/sampler"
+	"gpuwatch/internal/types"
+)
+
+const mib = 1024 * 1024
+
+// Source provides the cached state to expose; *sampler.Poller and
+// *sampler.HostPoller implement it.
+type Source interface {
+	Latest() (types.Snapshot, bool)
+	Health() sampler.Health
+}
+
+// Snapshots returns the latest snapshot of every host src samples: all of
+// them for a source with a Snapshots method, such as *sampler.HostPoller,
+// else the one from Latest.
+func Snapshots(src Source) []types.Snapshot {
+	if m, ok := src.(interface{ Snapshots() []types.Snapshot }); ok {
+		return m.Snapshots()
+	}
+	if s, ok := src.Latest(); ok {
+		return []types.Snapshot{s}
+	}
+	return nil
+}
+
+// Handler serves /metrics from src without sampling on scrape.
+func Handler(src Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		h := src.Health()
+		_ = Write(w, Snapshots(src), &h)
+	})
+}
+
+// Write renders GPU, process and user gauges for snaps (one per host) and,
+// if h is not nil, the sampler health counters.
+func Write(w io.Writer, snaps []types.Snapshot, h *sampler.Health) error {
+	bw := bufio.NewWriter(w)
+	e := &encoder{w: bw}
+
+	gpuGauges := []struct {
+		name, help string
+		value      func(types.GPU) float64
+	}{
+		{"gpuwatch_gpu_utilization_percent", "GPU utilization.", func(g types.GPU) float64 { return g.UtilGPU }},
+		{"gpuwatch_gpu_memory_utilization_percent", "GPU memory controller utilization.", func(g types.GPU) float64 { return g.UtilMem }},
+		{"gpuwatch_gpu_memory_used_bytes", "GPU memory in use.", func(g types.GPU) float64 { return g.MemUsedMB * mib }},
+		{"gpuwatch_gpu_memory_total_bytes", "GPU memory size.", func(g types.GPU) float64 { return g.MemTotalMB * mib }},
+		{"gpuwatch_gpu_temperature_celsius", "GPU temperature.", func(g types.GPU) float64 { return g.TempC }},
+		{"gpuwatch_gpu_power_watts", "GPU power draw.", func(g types.GPU) float64 { return g.PowerDrawW }},
+		{"gpuwatch_gpu_power_limit_watts", "GPU power limit.", func(g types.GPU) float64 { return g.PowerLimitW }},
+	}
+	for _, gg := range gpuGauges {
+		e.family(gg.name, gg.help, "gauge")
+		for _, s := range snaps {
+			for _, g := range s.GPUs {
+				e.sample(gg.name, gg.value(g), "host", s.Host.Hostname, "index", strconv.Itoa(g.Index), "uuid", g.UUID, "name", g.Name)
+			}
+		}
+	}
+
+	e.family("gpuwatch_process_memory_used_bytes", "GPU memory used by a process on one GPU.", "gauge")
+	for _, s := range snaps {
+		index := make(map[string]int, len(s.GPUs))
+		for _, g := range s.GPUs {
+			index[g.UUID] = g.Index
+		}
+		for _, p := range s.Procs {
+			e.sample("gpuwatch_process_memory_used_bytes", p.UsedMemMB*mib, "host", s.Host.Hostname, "user", p.User,
+				"pid", strconv.Itoa(p.PID), "process", p.ProcessName, "index", strconv.Itoa(index[p.GPUUUID]), "uuid", p.GPUUUID)
+		}
+	}
+
+	e.family("gpuwatch_user_memory_used_bytes", "GPU memory used by all processes of a user.", "gauge")
+	type userKey struct{ host, user string }
+	var keys []userKey
+	mem := make(map[userKey]float64)
+	procs := make(map[userKey]int)
+	for _, s := range snaps {
+		for _, p := range s.Procs {
+			k := userKey{s.Host.Hostname, p.User}
+			if _, ok := procs[k]; !ok {
+				keys = append(keys, k)
+			}
+			mem[k] += p.UsedMemMB
+			procs[k]++
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].host != keys[j].host {
+			return keys[i].host < keys[j].host
+		}
+		return keys[i].user < keys[j].user
+	})
+	for _, k := range keys {
+		e.sample("gpuwatch_user_memory_used_bytes", mem[k]*mib, "host", k.host, "user", k.user)
+	}
+	e.family("gpuwatch_user_processes", "Number of GPU processes of a user.", "gauge")
+	for _, k := range keys {
+		e.sample("gpuwatch_user_processes", float64(procs[k]), "host", k.host, "user", k.user)
+	}
+
+	e.family("gpuwatch_snapshot_timestamp_seconds", "Time the exposed snapshot was taken.", "gauge")
+	for _, s := range snaps {
+		e.sample("gpuwatch_snapshot_timestamp_seconds", float64(s.TS.UnixNano())/1e9, "host", s.Host.Hostname)
+	}
+
+	if h != nil {
+		e.family("gpuwatch_sampler_samples_total", "Successful samples.", "counter")
+		e.sample("gpuwatch_sampler_samples_total", float64(h.Samples))
+		e.family("gpuwatch_sampler_errors_total", "Failed samples.", "counter")
+		e.sample("gpuwatch_sampler_errors_total", float64(h.Errors))
+		e.family("gpuwatch_sampler_duration_seconds_total", "Time spent sampling.", "counter")
+		e.sample("gpuwatch_sampler_duration_seconds_total", h.TotalDuration.Seconds())
+		e.family("gpuwatch_sampler_last_duration_seconds", "Duration of the last sample.", "gauge")
+		e.sample("gpuwatch_sampler_last_duration_seconds", h.LastDuration.Seconds())
+		if !h.LastSuccess.IsZero() {
+			e.family("gpuwatch_sampler_last_success_timestamp_seconds", "Time of the last successful sample.", "gauge")
+			e.sample("gpuwatch_sampler_last_success_timestamp_seconds", float64(h.LastSuccess.UnixNano())/1e9)
+		}
+	}
+	if e.err != nil {
+		return e.err
+	}
+	return bw.Flush()
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *encoder) family(name, help, typ string) {
+	e.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes name{k1="v1",...} value; labels alternate keys and values.
+func (e *encoder) sample(name string, v float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(labelEscaper.Replace(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	e.printf("%s %s\n", b.String(), strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (e *encoder) printf(format string, args ...any) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,43 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+
+	"gpuwatch/internal/sampler"
+	"gpuwatch/internal/types"
+)
+
+// TextfileName is the file written into a node_exporter textfile-collector
+// directory.
+const TextfileName = "gpuwatch.prom"
+
+// WriteTextfile replaces dir/gpuwatch.prom with the metrics of snaps. The file
+// is written under a temporary name and renamed, so node_exporter never reads
+// a partial file.
+func WriteTextfile(dir string, snaps []types.Snapshot, h *sampler.Health) error {
+	f, err := os.CreateTemp(dir, "."+TextfileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := Write(f, snaps, h); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, TextfileName)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
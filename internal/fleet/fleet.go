@@ -0,0 +1,174 @@
+// Package fleet summarizes the latest snapshot of every host in a store for
+// fleet-wide views.
+package fleet
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// DefaultStaleAfter is how long a host may go without a snapshot before it
+// is reported as stale.
+const DefaultStaleAfter = 2 * time.Minute
+
+// Config holds the alert thresholds, as in the TUI.
+type Config struct {
+	MaxTemp    float64
+	MaxMem     float64 // memory utilization percent
+	StaleAfter time.Duration
+}
+
+// Host summarizes one host's latest snapshot.
+type Host struct {
+	Host       types.Host
+	SnapshotID int64
+	TS         time.Time
+	GPUs       int
+	FreeGPUs   int     // GPUs without processes
+	UtilGPU    float64 // mean over GPUs, percent
+	MemUsedMB  float64
+	MemTotalMB float64
+	PowerW     float64
+	Procs      int
+	Users      int
+	Alerts     []string
+	Stale      bool
+}
+
+// User is one user's footprint across the fleet.
+type User struct {
+	User      string
+	MemUsedMB float64
+	Procs     int
+	GPUs      int // GPUs with at least one of the user's processes
+	Hosts     []string
+}
+
+// Summary is the fleet at one moment.
+type Summary struct {
+	Hosts []Host
+	Users []User
+}
+
+// Load reads the latest snapshot of every host in db, sorted by hostname.
+func Load(db store.Store) ([]types.Snapshot, error) {
+	hosts, err := db.ListHosts()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var out []types.Snapshot
+	for _, h := range hosts {
+		if seen[h.Hostname] {
+			continue
+		}
+		seen[h.Hostname] = true
+		s, err := db.LoadLatest(h.Hostname)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// Summarize builds the host list and fleet-wide per-user totals of snaps
+// (one per host), as seen at now.
+func Summarize(snaps []types.Snapshot, now time.Time, cfg Config) Summary {
+	if cfg.StaleAfter <= 0 {
+		cfg.StaleAfter = DefaultStaleAfter
+	}
+	var sum Summary
+	type userAgg struct {
+		User
+		gpus  map[string]bool
+		hosts map[string]bool
+	}
+	users := make(map[string]*userAgg)
+	for _, s := range snaps {
+		h := Host{Host: s.Host, SnapshotID: s.ID, TS: s.TS, GPUs: len(s.GPUs), Procs: len(s.Procs)}
+		busy := make(map[string]bool)
+		owners := make(map[string]bool)
+		for _, p := range s.Procs {
+			busy[p.GPUUUID] = true
+			owners[p.User] = true
+			u, ok := users[p.User]
+			if !ok {
+				u = &userAgg{User: User{User: p.User}, gpus: make(map[string]bool), hosts: make(map[string]bool)}
+				users[p.User] = u
+			}
+			u.MemUsedMB += p.UsedMemMB
+			u.Procs++
+			u.gpus[s.Host.Hostname+"/"+p.GPUUUID] = true
+			u.hosts[s.Host.Hostname] = true
+		}
+		h.Users = len(owners)
+		for _, g := range s.GPUs {
+			if !busy[g.UUID] {
+				h.FreeGPUs++
+			}
+			h.UtilGPU += g.UtilGPU
+			h.MemUsedMB += g.MemUsedMB
+			h.MemTotalMB += g.MemTotalMB
+			h.PowerW += g.PowerDrawW
+			if cfg.MaxTemp > 0 && g.TempC > cfg.MaxTemp {
+				h.Alerts = append(h.Alerts, fmt.Sprintf("GPU %d temp %.0f°C", g.Index, g.TempC))
+			}
+			if cfg.MaxMem > 0 && g.UtilMem > cfg.MaxMem {
+				h.Alerts = append(h.Alerts, fmt.Sprintf("GPU %d mem %.0f%%", g.Index, g.UtilMem))
+			}
+		}
+		if len(s.GPUs) > 0 {
+			h.UtilGPU /= float64(len(s.GPUs))
+		}
+		if age := now.Sub(s.TS); age > cfg.StaleAfter {
+			h.Stale = true
+			h.Alerts = append(h.Alerts, fmt.Sprintf("no data for %s", age.Round(time.Second)))
+		}
+		sum.Hosts = append(sum.Hosts, h)
+	}
+	sort.Slice(sum.Hosts, func(i, j int) bool { return sum.Hosts[i].Host.Hostname < sum.Hosts[j].Host.Hostname })
+
+	for _, u := range users {
+		u.GPUs = len(u.gpus)
+		for h := range u.hosts {
+			u.Hosts = append(u.Hosts, h)
+		}
+		sort.Strings(u.Hosts)
+		sum.Users = append(sum.Users, u.User)
+	}
+	sort.Slice(sum.Users, func(i, j int) bool {
+		if sum.Users[i].MemUsedMB != sum.Users[j].MemUsedMB {
+			return sum.Users[i].MemUsedMB > sum.Users[j].MemUsedMB
+		}
+		return sum.Users[i].User < sum.Users[j].User
+	})
+	return sum
+}
+
+// Totals adds up the hosts of a summary.
+func (s Summary) Totals() Host {
+	var t Host
+	t.Host.Hostname = "TOTAL"
+	for _, h := range s.Hosts {
+		t.GPUs += h.GPUs
+		t.FreeGPUs += h.FreeGPUs
+		t.UtilGPU += h.UtilGPU * float64(h.GPUs)
+		t.MemUsedMB += h.MemUsedMB
+		t.MemTotalMB += h.MemTotalMB
+		t.PowerW += h.PowerW
+		t.Procs += h.Procs
+		if len(h.Alerts) > 0 {
+			t.Alerts = append(t.Alerts, h.Host.Hostname)
+		}
+	}
+	if t.GPUs > 0 {
+		t.UtilGPU /= float64(t.GPUs)
+	}
+	t.Users = len(s.Users)
+	return t
+}
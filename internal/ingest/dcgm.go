@@ -0,0 +1,181 @@
+package ingest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// dcgmFields maps DCGM field names to the GPU reading they fill.
+var dcgmFields = map[string]func(g *types.GPU, v float64){
+	"DCGM_FI_DEV_GPU_UTIL":         func(g *types.GPU, v float64) { g.UtilGPU = v },
+	"DCGM_FI_DEV_MEM_COPY_UTIL":    func(g *types.GPU, v float64) { g.UtilMem = v },
+	"DCGM_FI_DEV_FB_USED":          func(g *types.GPU, v float64) { g.MemUsedMB = v },
+	"DCGM_FI_DEV_FB_TOTAL":         func(g *types.GPU, v float64) { g.MemTotalMB = v },
+	"DCGM_FI_DEV_GPU_TEMP":         func(g *types.GPU, v float64) { g.TempC = v },
+	"DCGM_FI_DEV_POWER_USAGE":      func(g *types.GPU, v float64) { g.PowerDrawW = v },
+	"DCGM_FI_DEV_POWER_MGMT_LIMIT": func(g *types.GPU, v float64) { g.PowerLimitW = v },
+}
+
+// dcgmScrape collects the samples sharing one timestamp.
+type dcgmScrape struct {
+	ts   time.Time
+	host string
+	gpus map[string]*types.GPU // by UUID, or index when UUID is missing
+	free map[string]float64    // DCGM_FI_DEV_FB_FREE, for exporters without FB_TOTAL
+}
+
+// ReadDCGM calls fn for every scrape in r, a dump of dcgm-exporter's
+// Prometheus text output (DCGM_FI_DEV_* samples labeled gpu, UUID, modelName
+// and Hostname). Samples are grouped into one snapshot per timestamp; samples
+// without a timestamp belong to a single snapshot at def. Unknown fields are
+// ignored.
+func ReadDCGM(r io.Reader, def time.Time, fn func(types.Snapshot) error) error {
+	var cur *dcgmScrape
+	flush := func() error {
+		if cur == nil || len(cur.gpus) == 0 {
+			return nil
+		}
+		return fn(cur.snapshot())
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	n := 0
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, labels, value, ts, err := parseSample(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		set, known := dcgmFields[name]
+		if !known && name != "DCGM_FI_DEV_FB_FREE" {
+			continue
+		}
+		if ts.IsZero() {
+			ts = def
+		}
+		if cur == nil || !cur.ts.Equal(ts) {
+			if err := flush(); err != nil {
+				return err
+			}
+			cur = &dcgmScrape{ts: ts, gpus: make(map[string]*types.GPU), free: make(map[string]float64)}
+		}
+		key := labels["UUID"]
+		if key == "" {
+			key = labels["gpu"]
+		}
+		g, ok := cur.gpus[key]
+		if !ok {
+			idx, _ := strconv.Atoi(labels["gpu"])
+			g = &types.GPU{Index: idx, UUID: labels["UUID"], Name: labels["modelName"]}
+			cur.gpus[key] = g
+		}
+		if h := labels["Hostname"]; h != "" {
+			cur.host = h
+		}
+		if known {
+			set(g, value)
+		} else {
+			cur.free[key] = value
+		}
+		n++
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no DCGM_FI_DEV samples found")
+	}
+	return flush()
+}
+
+func (c *dcgmScrape) snapshot() types.Snapshot {
+	s := types.Snapshot{TS: c.ts, Host: types.Host{Hostname: c.host}}
+	for key, g := range c.gpus {
+		if free, ok := c.free[key]; ok && g.MemTotalMB == 0 {
+			g.MemTotalMB = g.MemUsedMB + free
+		}
+		if g.UUID == "" {
+			g.UUID = fmt.Sprintf("GPU-%d", g.Index)
+		}
+		s.GPUs = append(s.GPUs, *g)
+	}
+	sort.Slice(s.GPUs, func(i, j int) bool { return s.GPUs[i].Index < s.GPUs[j].Index })
+	return s
+}
+
+// parseSample splits a Prometheus text sample: name{k="v",...} value [ms].
+func parseSample(line string) (name string, labels map[string]string, value float64, ts time.Time, err error) {
+	labels = make(map[string]string)
+	rest := line
+	i := strings.IndexAny(rest, "{ \t")
+	if i < 0 {
+		return "", nil, 0, ts, fmt.Errorf("missing value")
+	}
+	name, rest = rest[:i], rest[i:]
+	if strings.HasPrefix(rest, "{") {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, " ,")
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+			eq := strings.Index(rest, "=\"")
+			if eq < 0 {
+				return "", nil, 0, ts, fmt.Errorf("malformed labels")
+			}
+			key := strings.TrimSpace(rest[:eq])
+			rest = rest[eq+2:]
+			var b strings.Builder
+			closed := false
+			for i := 0; i < len(rest); i++ {
+				switch c := rest[i]; {
+				case c == '\\' && i+1 < len(rest):
+					i++
+					if rest[i] == 'n' {
+						b.WriteByte('\n')
+					} else {
+						b.WriteByte(rest[i])
+					}
+				case c == '"':
+					rest = rest[i+1:]
+					closed = true
+				default:
+					b.WriteByte(c)
+				}
+				if closed {
+					break
+				}
+			}
+			if !closed {
+				return "", nil, 0, ts, fmt.Errorf("unterminated label value")
+			}
+			labels[key] = b.String()
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", nil, 0, ts, fmt.Errorf("missing value")
+	}
+	if value, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return "", nil, 0, ts, err
+	}
+	if len(fields) > 1 {
+		ms, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return "", nil, 0, ts, fmt.Errorf("bad timestamp %q", fields[1])
+		}
+		ts = time.UnixMilli(ms)
+	}
+	return name, labels, value, ts, nil
+}
@@ -0,0 +1,160 @@
+// Package ingest converts monitoring data recorded by other tools into
+// snapshots.
+package ingest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// nvidia-smi prints its timestamp in the host's local time in this layout.
+const nvsmiTimeLayout = "Mon Jan _2 15:04:05 2006"
+
+type nvsmiLog struct {
+	Timestamp string     `xml:"timestamp"`
+	GPUs      []nvsmiGPU `xml:"gpu"`
+}
+
+type nvsmiGPU struct {
+	ProductName string `xml:"product_name"`
+	UUID        string `xml:"uuid"`
+	MinorNumber string `xml:"minor_number"`
+	FBMemory    struct {
+		Total string `xml:"total"`
+		Used  string `xml:"used"`
+	} `xml:"fb_memory_usage"`
+	Utilization struct {
+		GPU    string `xml:"gpu_util"`
+		Memory string `xml:"memory_util"`
+	} `xml:"utilization"`
+	Temperature struct {
+		GPU string `xml:"gpu_temp"`
+	} `xml:"temperature"`
+	Power    nvsmiPower `xml:"power_readings"`     // driver < 535
+	GPUPower nvsmiPower `xml:"gpu_power_readings"` // driver >= 535
+	Procs    []struct {
+		PID        string `xml:"pid"`
+		Type       string `xml:"type"`
+		Name       string `xml:"process_name"`
+		UsedMemory string `xml:"used_memory"`
+	} `xml:"processes>process_info"`
+}
+
+type nvsmiPower struct {
+	Draw         string `xml:"power_draw"`
+	InstantDraw  string `xml:"instant_power_draw"`
+	Limit        string `xml:"power_limit"`
+	CurrentLimit string `xml:"current_power_limit"`
+}
+
+// ReadNvidiaSMIXML calls fn for every <nvidia_smi_log> document in r, the
+// output of `nvidia-smi -q -x`, including logs appended by repeated runs.
+// Timestamps are read in loc. Process owners are not recorded by nvidia-smi
+// and are set to "?"; the snapshot host is left empty.
+func ReadNvidiaSMIXML(r io.Reader, loc *time.Location, fn func(types.Snapshot) error) error {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	n := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "nvidia_smi_log" {
+			continue
+		}
+		var doc nvsmiLog
+		if err := dec.DecodeElement(&doc, &se); err != nil {
+			return err
+		}
+		n++
+		s, err := doc.snapshot(loc)
+		if err != nil {
+			return fmt.Errorf("log %d: %w", n, err)
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	if n == 0 {
+		return fmt.Errorf("no nvidia_smi_log element found")
+	}
+	return nil
+}
+
+func (doc nvsmiLog) snapshot(loc *time.Location) (types.Snapshot, error) {
+	ts, err := time.ParseInLocation(nvsmiTimeLayout, strings.TrimSpace(doc.Timestamp), loc)
+	if err != nil {
+		return types.Snapshot{}, fmt.Errorf("timestamp %q: %w", doc.Timestamp, err)
+	}
+	s := types.Snapshot{TS: ts}
+	for i, g := range doc.GPUs {
+		idx := i
+		if v, err := strconv.Atoi(strings.TrimSpace(g.MinorNumber)); err == nil {
+			idx = v
+		}
+		p := g.GPUPower
+		if p == (nvsmiPower{}) {
+			p = g.Power
+		}
+		s.GPUs = append(s.GPUs, types.GPU{
+			Index:       idx,
+			Name:        strings.TrimSpace(g.ProductName),
+			UUID:        strings.TrimSpace(g.UUID),
+			UtilGPU:     number(g.Utilization.GPU),
+			UtilMem:     number(g.Utilization.Memory),
+			MemUsedMB:   number(g.FBMemory.Used),
+			MemTotalMB:  number(g.FBMemory.Total),
+			TempC:       number(g.Temperature.GPU),
+			PowerDrawW:  number(first(p.Draw, p.InstantDraw)),
+			PowerLimitW: number(first(p.Limit, p.CurrentLimit)),
+		})
+		for _, pr := range g.Procs {
+			if t := strings.TrimSpace(pr.Type); t != "" && !strings.Contains(t, "C") {
+				continue // graphics-only clients are not compute apps
+			}
+			pid, err := strconv.Atoi(strings.TrimSpace(pr.PID))
+			if err != nil {
+				continue
+			}
+			s.Procs = append(s.Procs, types.GPUProcess{
+				PID:         pid,
+				ProcessName: strings.TrimSpace(pr.Name),
+				UsedMemMB:   number(pr.UsedMemory),
+				GPUUUID:     strings.TrimSpace(g.UUID),
+				User:        "?",
+			})
+		}
+	}
+	return s, nil
+}
+
+// number parses the leading value of readings like "1024 MiB", "35 %" or
+// "N/A"; unavailable readings are 0.
+func number(s string) float64 {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return 0
+	}
+	v, _ := strconv.ParseFloat(f[0], 64)
+	return v
+}
+
+func first(vals ...string) string {
+	for _, v := range vals {
+		if v = strings.TrimSpace(v); v != "" && v != "N/A" {
+			return v
+		}
+	}
+	return ""
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -17,8 +18,20 @@ import (
 
 var ErrNoNvidiaSMI = errors.New("nvidia-smi not found or not working")
 
+var hostLabels map[string]string
+
+// SetHostLabels sets the labels attached to the host identity of every sample.
+func SetHostLabels(labels map[string]string) { hostLabels = labels }
+
+// LocalHost returns the identity of the machine gpuwatch runs on.
+func LocalHost() types.Host {
+	name, _ := os.Hostname()
+	return types.Host{Hostname: name, MachineID: util.ReadMachineID(), Labels: hostLabels}
+}
+
 // Sample queries nvidia-smi for GPU and per-process data and maps PIDs to usernames.
 func Sample() (types.Snapshot, error) {
+	start := time.Now()
 	if err := checkNvidiaSMI(); err != nil {
 		return types.Snapshot{}, err
 	}
@@ -47,9 +60,11 @@ func Sample() (types.Snapshot, error) {
 	}
 
 	return types.Snapshot{
-		TS:    time.Now(),
-		GPUs:  gpus,
-		Procs: procs,
+		TS:       start,
+		Duration: time.Since(start),
+		Host:     LocalHost(),
+		GPUs:     gpus,
+		Procs:    procs,
 	}, nil
 }
 
@@ -67,6 +82,11 @@ func queryGPUs() ([]types.GPU, error) {
 	if err != nil {
 		return nil, fmt.Errorf("nvidia-smi gpu query: %w", err)
 	}
+	return parseGPUs(out)
+}
+
+// parseGPUs reads the CSV output of the GPU query.
+func parseGPUs(out []byte) ([]types.GPU, error) {
 	reader := csv.NewReader(strings.NewReader(string(out)))
 	reader.TrimLeadingSpace = true
 	reader.FieldsPerRecord = -1
@@ -112,7 +132,17 @@ func queryProcs() ([]types.GPUProcess, error) {
 	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
-	s := bufio.NewScanner(stdout)
+	res, err := parseProcs(stdout)
+	if err != nil {
+		return nil, err
+	}
+	_ = cmd.Wait()
+	return res, nil
+}
+
+// parseProcs reads the CSV output of the compute-apps query.
+func parseProcs(r io.Reader) ([]types.GPUProcess, error) {
+	s := bufio.NewScanner(r)
 	var res []types.GPUProcess
 	for s.Scan() {
 		line := strings.TrimSpace(s.Text())
@@ -133,7 +163,6 @@ func queryProcs() ([]types.GPUProcess, error) {
 	if err := s.Err(); err != nil {
 		return nil, err
 	}
-	_ = cmd.Wait()
 	return res, nil
 }
 
@@ -0,0 +1,85 @@
+package sampler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// Health counts sampler runs for monitoring the monitor.
+type Health struct {
+	Samples       uint64        // successful samples
+	Errors        uint64        // failed samples
+	LastDuration  time.Duration // of the last sample, successful or not
+	TotalDuration time.Duration // of all samples
+	LastSuccess   time.Time
+	LastErr       error
+}
+
+// Poller samples on a fixed interval and caches the latest snapshot, so any
+// number of readers (HTTP scrapes, UIs) share one nvidia-smi run per interval.
+type Poller struct {
+	interval time.Duration
+	sample   func() (types.Snapshot, error)
+
+	mu     sync.RWMutex
+	latest types.Snapshot
+	ok     bool
+	health Health
+}
+
+// NewPoller returns a poller running Sample every interval.
+func NewPoller(interval time.Duration) *Poller {
+	return &Poller{interval: interval, sample: Sample}
+}
+
+// Run samples immediately and then every interval until ctx is done, calling
+// fn (if not nil) with each result after the cache is updated.
+func (p *Poller) Run(ctx context.Context, fn func(types.Snapshot, error)) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		s, err := p.sample()
+		d := time.Since(start)
+
+		p.mu.Lock()
+		p.health.LastDuration = d
+		p.health.TotalDuration += d
+		if err != nil {
+			p.health.Errors++
+			p.health.LastErr = err
+		} else {
+			p.health.Samples++
+			p.health.LastSuccess = time.Now()
+			p.latest, p.ok = s, true
+		}
+		p.mu.Unlock()
+
+		if fn != nil {
+			fn(s, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Latest returns the most recent successful snapshot; ok is false before the
+// first one.
+func (p *Poller) Latest() (s types.Snapshot, ok bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.latest, p.ok
+}
+
+// Health returns a copy of the sampler counters.
+func (p *Poller) Health() Health {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.health
+}
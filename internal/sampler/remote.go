@@ -0,0 +1,287 @@
+package sampler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// Runner runs a POSIX shell script on a host and returns its standard output.
+type Runner interface {
+	Run(ctx context.Context, script string) ([]byte, error)
+}
+
+// Local runs scripts on this machine; it stands in for SSH in tests.
+type Local struct{}
+
+func (Local) Run(ctx context.Context, script string) ([]byte, error) {
+	return runScript(exec.CommandContext(ctx, "sh", "-s"), script)
+}
+
+// SSH runs scripts on Target with the ssh binary, so the user's ssh config,
+// keys and agent apply. BatchMode keeps ssh from prompting for passwords or
+// host keys.
+type SSH struct {
+	Target  string   // [user@]host, or an alias from ~/.ssh/config
+	Command string   // default "ssh"
+	Options []string // extra ssh arguments, e.g. -p 2222
+}
+
+func (s SSH) Run(ctx context.Context, script string) ([]byte, error) {
+	name := s.Command
+	if name == "" {
+		name = "ssh"
+	}
+	args := []string{"-o", "BatchMode=yes"}
+	if d, ok := ctx.Deadline(); ok {
+		secs := max(int(time.Until(d).Round(time.Second).Seconds()), 1)
+		args = append(args, "-o", "ConnectTimeout="+strconv.Itoa(secs))
+	}
+	args = append(args, s.Options...)
+	args = append(args, "--", s.Target, "sh", "-s")
+	return runScript(exec.CommandContext(ctx, name, args...), script)
+}
+
+func runScript(cmd *exec.Cmd, script string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.WaitDelay = time.Second // a killed ssh may leave the pipes open
+	if err := cmd.Run(); err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == noNvidiaSMIExit {
+			return nil, ErrNoNvidiaSMI
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// noNvidiaSMIExit is the exit status of remoteScript when nvidia-smi fails.
+const noNvidiaSMIExit = 97
+
+const sectionMark = "@@gpuwatch@@"
+
+// remoteScript does the work of Sample in one round trip: the hostname,
+// machine ID, both nvidia-smi queries and the real UID and user name of
+// every GPU process, in sections separated by sectionMark.
+const remoteScript = `hostname 2>/dev/null || uname -n
+echo '` + sectionMark + `'
+cat /etc/machine-id 2>/dev/null || cat /var/lib/dbus/machine-id 2>/dev/null
+echo '` + sectionMark + `'
+nvidia-smi --query-gpu=index,name,uuid,utilization.gpu,utilization.memory,memory.used,memory.total,temperature.gpu,power.draw,power.limit --format=csv,noheader,nounits || exit 97
+echo '` + sectionMark + `'
+apps=$(nvidia-smi --query-compute-apps=pid,process_name,used_memory,gpu_uuid --format=csv,noheader,nounits 2>/dev/null)
+printf '%s\n' "$apps"
+echo '` + sectionMark + `'
+for pid in $(printf '%s\n' "$apps" | cut -d, -f1); do
+	uid=$(awk '/^Uid:/ {print $2}' /proc/$pid/status 2>/dev/null)
+	if [ -n "$uid" ]; then
+		echo "$pid $uid $(getent passwd "$uid" 2>/dev/null | cut -d: -f1)"
+	fi
+done
+`
+
+// SampleRemote samples the host r runs on. The snapshot is tagged with the
+// remote hostname and machine ID.
+func SampleRemote(ctx context.Context, r Runner) (types.Snapshot, error) {
+	start := time.Now()
+	out, err := r.Run(ctx, remoteScript)
+	if err != nil {
+		return types.Snapshot{}, err
+	}
+	sec := strings.Split(string(out), sectionMark+"\n")
+	if len(sec) != 5 {
+		return types.Snapshot{}, errors.New("remote: unexpected output")
+	}
+	gpus, err := parseGPUs([]byte(sec[2]))
+	if err != nil {
+		return types.Snapshot{}, err
+	}
+	procs, err := parseProcs(strings.NewReader(sec[3]))
+	if err != nil {
+		procs = nil
+	}
+	users := make(map[int]string) // pid -> user
+	sc := bufio.NewScanner(strings.NewReader(sec[4]))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		switch {
+		case len(f) >= 3:
+			users[atoi(f[0])] = f[2]
+		case len(f) == 2:
+			users[atoi(f[0])] = "uid:" + f[1]
+		}
+	}
+	for i := range procs {
+		if u, ok := users[procs[i].PID]; ok {
+			procs[i].User = u
+		} else {
+			procs[i].User = "?"
+		}
+	}
+	return types.Snapshot{
+		TS:       start,
+		Duration: time.Since(start),
+		Host:     types.Host{Hostname: strings.TrimSpace(sec[0]), MachineID: strings.TrimSpace(sec[1])},
+		GPUs:     gpus,
+		Procs:    procs,
+	}, nil
+}
+
+// Target is a host sampled with SampleRemote.
+type Target struct {
+	Name   string            // reported when the host cannot be reached
+	Labels map[string]string // attached to the host identity
+	Runner Runner
+}
+
+// HostPoller samples a set of targets in parallel on a fixed interval and
+// caches the latest snapshot of each.
+type HostPoller struct {
+	interval time.Duration
+	timeout  time.Duration
+	targets  []Target
+
+	mu     sync.RWMutex
+	latest map[string]types.Snapshot // by target name
+	down   []error                   // of the last round
+	health Health
+}
+
+// NewHostPoller returns a poller sampling targets every interval, giving
+// each host at most timeout per sample.
+func NewHostPoller(targets []Target, interval, timeout time.Duration) *HostPoller {
+	return &HostPoller{interval: interval, timeout: timeout, targets: targets,
+		latest: make(map[string]types.Snapshot)}
+}
+
+// HostResult is one target's sample of a round.
+type HostResult struct {
+	Target string
+	Snap   types.Snapshot
+	Err    error
+}
+
+// Collect samples every target once, in parallel, and returns the results
+// in target order. Errors name the target.
+func (p *HostPoller) Collect(ctx context.Context) []HostResult {
+	res := make([]HostResult, len(p.targets))
+	var wg sync.WaitGroup
+	for i, t := range p.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tctx, cancel := context.WithTimeout(ctx, p.timeout)
+			defer cancel()
+			s, err := SampleRemote(tctx, t.Runner)
+			if err != nil {
+				if tctx.Err() == context.DeadlineExceeded {
+					err = fmt.Errorf("timed out after %s", p.timeout)
+				}
+				res[i] = HostResult{Target: t.Name, Err: fmt.Errorf("%s: %w", t.Name, err)}
+				return
+			}
+			if s.Host.Hostname == "" {
+				s.Host.Hostname = t.Name
+			}
+			s.Host.Labels = t.Labels
+			res[i] = HostResult{Target: t.Name, Snap: s}
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+// Run collects immediately and then every interval until ctx is done,
+// calling fn (if not nil) with each host's result after the cache is
+// updated.
+func (p *HostPoller) Run(ctx context.Context, fn func(HostResult)) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		res := p.Collect(ctx)
+		d := time.Since(start)
+
+		p.mu.Lock()
+		p.health.LastDuration = d
+		p.health.TotalDuration += d
+		p.down = nil
+		for _, r := range res {
+			if r.Err != nil {
+				p.health.Errors++
+				p.health.LastErr = r.Err
+				p.down = append(p.down, r.Err)
+				continue
+			}
+			p.health.Samples++
+			p.health.LastSuccess = time.Now()
+			p.latest[r.Target] = r.Snap
+		}
+		p.mu.Unlock()
+
+		if fn != nil {
+			for _, r := range res {
+				fn(r)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Snapshots returns the latest snapshot of every host sampled so far, in
+// target order.
+func (p *HostPoller) Snapshots() []types.Snapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var out []types.Snapshot
+	for _, t := range p.targets {
+		if s, ok := p.latest[t.Name]; ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Latest returns the most recent snapshot of any host.
+func (p *HostPoller) Latest() (s types.Snapshot, ok bool) {
+	for _, snap := range p.Snapshots() {
+		if !ok || snap.TS.After(s.TS) {
+			s, ok = snap, true
+		}
+	}
+	return s, ok
+}
+
+// Hosts returns the number of targets.
+func (p *HostPoller) Hosts() int { return len(p.targets) }
+
+// Down returns the errors of the hosts that failed in the last round.
+func (p *HostPoller) Down() []error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.down
+}
+
+// Health returns a copy of the sampler counters, over all hosts.
+func (p *HostPoller) Health() Health {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.health
+}
@@ -0,0 +1,163 @@
+package sampler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// fakeRunner returns canned script output after an optional delay.
+type fakeRunner struct {
+	out   string
+	err   error
+	delay time.Duration
+}
+
+func (f fakeRunner) Run(ctx context.Context, script string) ([]byte, error) {
+	if !strings.Contains(script, "nvidia-smi") {
+		return nil, errors.New("not the remote script")
+	}
+	time.Sleep(f.delay)
+	return []byte(f.out), f.err
+}
+
+// blockingRunner never answers before ctx is done, like an unresponsive ssh.
+type blockingRunner struct{}
+
+func (blockingRunner) Run(ctx context.Context, _ string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// remoteOutput builds remoteScript output for host.
+func remoteOutput(host, machineID string) string {
+	return strings.Join([]string{
+		host + "\n",
+		machineID + "\n",
+		"0, NVIDIA A100-SXM4-40GB, GPU-aaa, 87, 40, 30000, 40960, 65, 310.50, 400.00\n" +
+			"1, NVIDIA A100-SXM4-40GB, GPU-bbb, 0, 0, 2, 40960, 31, 55.10, 400.00\n",
+		"1234, python, 28000, GPU-aaa\n" +
+			"1300, /usr/bin/train, 1000, GPU-aaa\n" +
+			"1400, gone, 500, GPU-bbb\n",
+		"1234 1000 alice\n" +
+			"1300 1001\n", // no passwd entry; 1400 exited before the lookup
+	}, sectionMark+"\n")
+}
+
+func TestSampleRemote(t *testing.T) {
+	s, err := SampleRemote(context.Background(), fakeRunner{out: remoteOutput("node1", "abc123")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Host.Hostname != "node1" || s.Host.MachineID != "abc123" {
+		t.Errorf("host = %+v", s.Host)
+	}
+	if s.TS.IsZero() || s.Duration < 0 {
+		t.Errorf("TS %v, duration %v", s.TS, s.Duration)
+	}
+	want := types.GPU{Index: 0, Name: "NVIDIA A100-SXM4-40GB", UUID: "GPU-aaa", UtilGPU: 87, UtilMem: 40,
+		MemUsedMB: 30000, MemTotalMB: 40960, TempC: 65, PowerDrawW: 310.5, PowerLimitW: 400}
+	if len(s.GPUs) != 2 || s.GPUs[0] != want || s.GPUs[1].UUID != "GPU-bbb" {
+		t.Errorf("GPUs = %+v", s.GPUs)
+	}
+	wantProcs := []types.GPUProcess{
+		{PID: 1234, ProcessName: "python", UsedMemMB: 28000, GPUUUID: "GPU-aaa", User: "alice"},
+		{PID: 1300, ProcessName: "/usr/bin/train", UsedMemMB: 1000, GPUUUID: "GPU-aaa", User: "uid:1001"},
+		{PID: 1400, ProcessName: "gone", UsedMemMB: 500, GPUUUID: "GPU-bbb", User: "?"},
+	}
+	if len(s.Procs) != len(wantProcs) {
+		t.Fatalf("procs = %+v", s.Procs)
+	}
+	for i := range wantProcs {
+		if s.Procs[i] != wantProcs[i] {
+			t.Errorf("proc %d = %+v, want %+v", i, s.Procs[i], wantProcs[i])
+		}
+	}
+
+	if _, err := SampleRemote(context.Background(), fakeRunner{out: "node1\n"}); err == nil {
+		t.Error("truncated output accepted")
+	}
+}
+
+// TestRemoteNoNvidiaSMI runs the real script with an nvidia-smi that fails.
+func TestRemoteNoNvidiaSMI(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "nvidia-smi"), []byte("#!/bin/sh\necho 'driver not loaded' >&2\nexit 9\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if _, err := SampleRemote(context.Background(), Local{}); err != ErrNoNvidiaSMI {
+		t.Errorf("SampleRemote = %v, want ErrNoNvidiaSMI", err)
+	}
+	_, err := Local{}.Run(context.Background(), "echo oops >&2; exit 3")
+	if err == nil || err == ErrNoNvidiaSMI || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("other failure = %v, want the exit status with stderr", err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	p := NewHostPoller([]Target{
+		{Name: "a", Runner: fakeRunner{out: remoteOutput("node-a", "m-a"), delay: 30 * time.Millisecond}},
+		{Name: "b", Runner: fakeRunner{err: errors.New("connection refused")}},
+		{Name: "c", Runner: fakeRunner{out: remoteOutput("", "m-c")}, Labels: map[string]string{"rack": "r2"}},
+		{Name: "d", Runner: blockingRunner{}},
+	}, time.Minute, 100*time.Millisecond)
+
+	start := time.Now()
+	res := p.Collect(context.Background())
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Collect took %s with a 100ms timeout per host", d)
+	}
+	if len(res) != 4 {
+		t.Fatalf("%d results, want 4", len(res))
+	}
+	for i, name := range []string{"a", "b", "c", "d"} { // target order, not completion order
+		if res[i].Target != name {
+			t.Errorf("result %d is %s, want %s", i, res[i].Target, name)
+		}
+	}
+	if res[0].Err != nil || res[0].Snap.Host.Hostname != "node-a" {
+		t.Errorf("a = %+v", res[0])
+	}
+	if res[1].Err == nil || res[1].Err.Error() != "b: connection refused" {
+		t.Errorf("b: %v, want the error named by the target", res[1].Err)
+	}
+	if h := res[2].Snap.Host; res[2].Err != nil || h.Hostname != "c" || h.Labels["rack"] != "r2" {
+		t.Errorf("c = %+v, want the target name for an empty hostname and its labels", res[2])
+	}
+	if res[3].Err == nil || res[3].Err.Error() != "d: timed out after 100ms" {
+		t.Errorf("d: %v, want a timeout", res[3].Err)
+	}
+}
+
+func TestHostPollerRun(t *testing.T) {
+	p := NewHostPoller([]Target{
+		{Name: "a", Runner: fakeRunner{out: remoteOutput("node-a", "m-a")}},
+		{Name: "b", Runner: fakeRunner{err: errors.New("connection refused")}},
+		{Name: "c", Runner: fakeRunner{out: remoteOutput("node-c", "m-c")}},
+	}, time.Minute, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // one round only
+	var seen []string
+	p.Run(ctx, func(r HostResult) { seen = append(seen, r.Target) })
+
+	if strings.Join(seen, ",") != "a,b,c" {
+		t.Errorf("fn called for %v", seen)
+	}
+	snaps := p.Snapshots()
+	if len(snaps) != 2 || snaps[0].Host.Hostname != "node-a" || snaps[1].Host.Hostname != "node-c" {
+		t.Errorf("snapshots = %+v", snaps)
+	}
+	if down := p.Down(); len(down) != 1 || !strings.HasPrefix(down[0].Error(), "b:") {
+		t.Errorf("down = %v", down)
+	}
+	if h := p.Health(); h.Samples != 2 || h.Errors != 1 {
+		t.Errorf("health = %+v", h)
+	}
+}
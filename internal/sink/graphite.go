@@ -0,0 +1,149 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+func init() {
+	Register("graphite", openGraphite)
+}
+
+// DefaultGraphitePrefix starts every Graphite metric path.
+const DefaultGraphitePrefix = "gpuwatch"
+
+// WritePlaintext renders snaps in the Graphite plaintext protocol, one
+// "path value timestamp" line per metric: <prefix>.<host>.gpu.<index>.<metric>
+// and <prefix>.<host>.user.<user>.<metric>. Dots and other separators in
+// path components become underscores.
+func WritePlaintext(w io.Writer, prefix string, snaps []types.Snapshot) error {
+	var b bytes.Buffer
+	for _, s := range snaps {
+		ts := strconv.FormatInt(s.TS.Unix(), 10)
+		base := prefix + "." + pathPart(s.Host.Hostname)
+		put := func(path string, v float64) {
+			fmt.Fprintf(&b, "%s.%s %s %s\n", base, path, strconv.FormatFloat(v, 'f', -1, 64), ts)
+		}
+		for _, g := range s.GPUs {
+			gpu := "gpu." + strconv.Itoa(g.Index) + "."
+			put(gpu+"util_percent", g.UtilGPU)
+			put(gpu+"mem_util_percent", g.UtilMem)
+			put(gpu+"mem_used_mb", g.MemUsedMB)
+			put(gpu+"mem_total_mb", g.MemTotalMB)
+			put(gpu+"temp_c", g.TempC)
+			put(gpu+"power_w", g.PowerDrawW)
+			put(gpu+"power_limit_w", g.PowerLimitW)
+		}
+		mem := make(map[string]float64)
+		procs := make(map[string]int)
+		for _, p := range s.Procs {
+			mem[p.User] += p.UsedMemMB
+			procs[p.User]++
+		}
+		users := make([]string, 0, len(mem))
+		for u := range mem {
+			users = append(users, u)
+		}
+		sort.Strings(users)
+		for _, u := range users {
+			put("user."+pathPart(u)+".mem_used_mb", mem[u])
+			put("user."+pathPart(u)+".procs", float64(procs[u]))
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// pathPart makes s safe as one component of a Graphite path.
+func pathPart(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// graphite writes to a carbon plaintext listener over TCP, keeping the
+// connection open between batches and redialing after an error.
+type graphite struct {
+	addr   string
+	prefix string
+	conn   net.Conn
+}
+
+func openGraphite(u *url.URL, opts Options) (Sink, error) {
+	if u.Host == "" {
+		return nil, errors.New("missing host, e.g. graphite://host:2003")
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "2003")
+	}
+	prefix := DefaultGraphitePrefix
+	if p := u.Query().Get("prefix"); p != "" {
+		prefix = strings.Trim(p, ".")
+	}
+	return &graphite{addr: addr, prefix: prefix}, nil
+}
+
+func (s *graphite) Write(ctx context.Context, batch []types.Snapshot) error {
+	var buf bytes.Buffer
+	if err := WritePlaintext(&buf, s.prefix, batch); err != nil {
+		return err
+	}
+	if s.conn != nil && !alive(s.conn) {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if d, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(d)
+	}
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// alive reports whether the server has kept conn open. Carbon never sends,
+// so anything but a read timeout means the connection is gone, and a write
+// would be lost.
+func alive(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	var one [1]byte
+	_, err := conn.Read(one[:])
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func (s *graphite) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,102 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// TestGraphiteRedial checks that a batch written after the server closed
+// the connection goes to a new one instead of being lost.
+func TestGraphiteRedial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conns := make(chan net.Conn)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+	accept := func() net.Conn {
+		t.Helper()
+		select {
+		case c := <-conns:
+			return c
+		case <-time.After(2 * time.Second):
+			t.Fatal("no connection")
+			return nil
+		}
+	}
+	read := func(c net.Conn, snaps []types.Snapshot) {
+		t.Helper()
+		var want bytes.Buffer
+		WritePlaintext(&want, "lab.gpus", snaps)
+		got := make([]byte, want.Len())
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want.String() {
+			t.Errorf("got\n%s\nwant\n%s", got, want.String())
+		}
+	}
+
+	sk, err := Open("graphite://"+ln.Addr().String()+"?prefix=.lab.gpus.", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sk.Close()
+	first := []types.Snapshot{testSnapshot(time.Unix(1700000000, 0), 1)}
+	if err := sk.Write(context.Background(), first); err != nil {
+		t.Fatal(err)
+	}
+	c1 := accept()
+	read(c1, first)
+
+	second := []types.Snapshot{testSnapshot(time.Unix(1700000010, 0), 1)}
+	if err := sk.Write(context.Background(), second); err != nil {
+		t.Fatal(err)
+	}
+	read(c1, second) // same connection while it stays open
+
+	c1.Close()
+	time.Sleep(50 * time.Millisecond) // let the FIN arrive
+	third := []types.Snapshot{testSnapshot(time.Unix(1700000020, 0), 1)}
+	if err := sk.Write(context.Background(), third); err != nil {
+		t.Fatal(err)
+	}
+	c2 := accept()
+	defer c2.Close()
+	read(c2, third)
+}
+
+func TestWritePlaintext(t *testing.T) {
+	s := testSnapshot(time.Unix(1700000000, 0), 1)
+	s.Host.Hostname = "node1.example.com"
+	s.Procs[1].User = "bob.smith"
+	var b bytes.Buffer
+	WritePlaintext(&b, DefaultGraphitePrefix, []types.Snapshot{s})
+	for _, want := range []string{
+		"gpuwatch.node1_example_com.gpu.0.util_percent 87 1700000000\n",
+		"gpuwatch.node1_example_com.gpu.0.power_w 310.5 1700000000\n",
+		"gpuwatch.node1_example_com.user.alice.mem_used_mb 1500 1700000000\n",
+		"gpuwatch.node1_example_com.user.bob_smith.procs 1 1700000000\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gpuwatch/internal/types"
+)
+
+func init() {
+	Register("influx+http", openInfluxHTTP)
+	Register("influx+https", openInfluxHTTP)
+	Register("influx+udp", openInfluxUDP)
+}
+
+// WriteLines renders snaps in InfluxDB line protocol with nanosecond
+// timestamps: gpuwatch_gpu per GPU, gpuwatch_process per process and
+// gpuwatch_user per user, tagged with the host, its labels and the GPU.
+func WriteLines(w io.Writer, snaps []types.Snapshot) error {
+	var b bytes.Buffer
+	for _, s := range snaps {
+		ts := strconv.FormatInt(s.TS.UnixNano(), 10)
+		host := hostTags(s.Host)
+		index := make(map[string]int, len(s.GPUs))
+		for _, g := range s.GPUs {
+			index[g.UUID] = g.Index
+			line(&b, "gpuwatch_gpu", host, []string{"index", strconv.Itoa(g.Index), "uuid", g.UUID, "name", g.Name},
+				[]string{"util_percent", float(g.UtilGPU), "mem_util_percent", float(g.UtilMem),
+					"mem_used_mb", float(g.MemUsedMB), "mem_total_mb", float(g.MemTotalMB), "temp_c", float(g.TempC),
+					"power_w", float(g.PowerDrawW), "power_limit_w", float(g.PowerLimitW)}, ts)
+		}
+		type agg struct {
+			mem   float64
+			procs int
+		}
+		users := make(map[string]*agg)
+		var names []string
+		for _, p := range s.Procs {
+			line(&b, "gpuwatch_process", host, []string{"user", p.User, "pid", strconv.Itoa(p.PID),
+				"index", strconv.Itoa(index[p.GPUUUID]), "uuid", p.GPUUUID},
+				[]string{"mem_used_mb", float(p.UsedMemMB), "process", `"` + fieldEscaper.Replace(p.ProcessName) + `"`}, ts)
+			u, ok := users[p.User]
+			if !ok {
+				u = &agg{}
+				users[p.User] = u
+				names = append(names, p.User)
+			}
+			u.mem += p.UsedMemMB
+			u.procs++
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			line(&b, "gpuwatch_user", host, []string{"user", n},
+				[]string{"mem_used_mb", float(users[n].mem), "procs", strconv.Itoa(users[n].procs) + "i"}, ts)
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// hostTags are the host name and labels; labels named like a gpuwatch tag
+// are left out.
+func hostTags(h types.Host) []string {
+	tags := []string{"host", h.Hostname}
+	keys := make([]string, 0, len(h.Labels))
+	for k := range h.Labels {
+		switch k {
+		case "host", "index", "uuid", "name", "user", "pid":
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		tags = append(tags, k, h.Labels[k])
+	}
+	return tags
+}
+
+// line writes one point; tags and fields alternate keys and values, field
+// values already formatted. Empty tag values are left out, as InfluxDB
+// rejects them.
+func line(b *bytes.Buffer, measurement string, host, tags, fields []string, ts string) {
+	b.WriteString(measurement)
+	for _, t := range [][]string{host, tags} {
+		for i := 0; i+1 < len(t); i += 2 {
+			if t[i+1] == "" {
+				continue
+			}
+			b.WriteByte(',')
+			b.WriteString(tagEscaper.Replace(t[i]))
+			b.WriteByte('=')
+			b.WriteString(tagEscaper.Replace(t[i+1]))
+		}
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(tagEscaper.Replace(fields[i]))
+		b.WriteByte('=')
+		b.WriteString(fields[i+1])
+	}
+	b.WriteByte(' ')
+	b.WriteString(ts)
+	b.WriteByte('\n')
+}
+
+var (
+	tagEscaper   = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	fieldEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+func float(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+// influxHTTP posts line protocol to a write endpoint: /write?db= (v1) or
+// /api/v2/write?org=&bucket= (v2).
+type influxHTTP struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func openInfluxHTTP(u *url.URL, opts Options) (Sink, error) {
+	if u.Path == "" || u.Path == "/" {
+		return nil, errors.New("give the write URL, e.g. influx+http://host:8086/api/v2/write?org=lab&bucket=gpu or influx+http://host:8086/write?db=gpu")
+	}
+	w := *u
+	w.Scheme = strings.TrimPrefix(u.Scheme, "influx+")
+	return &influxHTTP{url: w.String(), token: opts.Token, client: &http.Client{}}, nil
+}
+
+func (s *influxHTTP) Write(ctx context.Context, batch []types.Snapshot) error {
+	var body bytes.Buffer
+	if err := WriteLines(&body, batch); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("influx: %w: %s: %s", ErrRejected, resp.Status, bytes.TrimSpace(msg))
+	default:
+		return fmt.Errorf("influx: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+}
+
+func (s *influxHTTP) Close() error { return nil }
+
+// maxDatagram keeps UDP packets within a common MTU so they are not
+// fragmented; InfluxDB takes one or more whole lines per packet.
+const maxDatagram = 1400
+
+// influxUDP sends line protocol to an InfluxDB UDP listener. Delivery is
+// not acknowledged, so only local errors are reported.
+type influxUDP struct {
+	conn net.Conn
+}
+
+func openInfluxUDP(u *url.URL, opts Options) (Sink, error) {
+	if u.Port() == "" {
+		return nil, errors.New("missing port, e.g. influx+udp://host:8089")
+	}
+	conn, err := net.Dial("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &influxUDP{conn: conn}, nil
+}
+
+func (s *influxUDP) Write(ctx context.Context, batch []types.Snapshot) error {
+	var buf bytes.Buffer
+	if err := WriteLines(&buf, batch); err != nil {
+		return err
+	}
+	if d, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(d)
+	}
+	var packet []byte
+	for _, l := range bytes.SplitAfter(buf.Bytes(), []byte("\n")) {
+		if len(packet) > 0 && len(packet)+len(l) > maxDatagram {
+			if _, err := s.conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		packet = append(packet, l...)
+	}
+	if len(packet) > 0 {
+		_, err := s.conn.Write(packet)
+		return err
+	}
+	return nil
+}
+
+func (s *influxUDP) Close() error { return s.conn.Close() }
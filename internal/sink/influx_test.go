@@ -0,0 +1,186 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+func testSnapshot(ts time.Time, gpus int) types.Snapshot {
+	s := types.Snapshot{
+		TS:   ts,
+		Host: types.Host{Hostname: "node1", Labels: map[string]string{"rack": "r 2"}},
+		Procs: []types.GPUProcess{
+			{PID: 42, ProcessName: `my "job"`, UsedMemMB: 1500, GPUUUID: "GPU-0", User: "alice"},
+			{PID: 43, ProcessName: "eval", UsedMemMB: 500, GPUUUID: "GPU-0", User: "alice"},
+		},
+	}
+	for i := 0; i < gpus; i++ {
+		s.GPUs = append(s.GPUs, types.GPU{Index: i, Name: "NVIDIA A100", UUID: "GPU-" + strconv.Itoa(i),
+			UtilGPU: 87, UtilMem: 40, MemUsedMB: 2000, MemTotalMB: 40960, TempC: 65, PowerDrawW: 310.5, PowerLimitW: 400})
+	}
+	return s
+}
+
+func TestWriteLines(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteLines(&b, []types.Snapshot{testSnapshot(time.Unix(1700000000, 5), 1)}); err != nil {
+		t.Fatal(err)
+	}
+	want := `gpuwatch_gpu,host=node1,rack=r\ 2,index=0,uuid=GPU-0,name=NVIDIA\ A100 util_percent=87,mem_util_percent=40,mem_used_mb=2000,mem_total_mb=40960,temp_c=65,power_w=310.5,power_limit_w=400 1700000000000000005
+gpuwatch_process,host=node1,rack=r\ 2,user=alice,pid=42,index=0,uuid=GPU-0 mem_used_mb=1500,process="my \"job\"" 1700000000000000005
+gpuwatch_process,host=node1,rack=r\ 2,user=alice,pid=43,index=0,uuid=GPU-0 mem_used_mb=500,process="eval" 1700000000000000005
+gpuwatch_user,host=node1,rack=r\ 2,user=alice mem_used_mb=2000,procs=2i 1700000000000000005
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+// influxServer answers writes with the given status codes in turn, then 204,
+// recording each request body.
+type influxServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	codes  []int
+	bodies []string
+	auth   []string
+}
+
+func newInfluxServer(t *testing.T, codes ...int) *influxServer {
+	s := &influxServer{codes: codes}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("bucket") != "gpu" {
+			t.Errorf("write to %s", r.URL)
+		}
+		s.bodies = append(s.bodies, string(body))
+		s.auth = append(s.auth, r.Header.Get("Authorization"))
+		code := http.StatusNoContent
+		if len(s.codes) > 0 {
+			code, s.codes = s.codes[0], s.codes[1:]
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *influxServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+func (s *influxServer) open(t *testing.T) Sink {
+	t.Helper()
+	sk, err := Open("influx+"+s.URL+"/api/v2/write?org=lab&bucket=gpu", Options{Token: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk
+}
+
+func TestInfluxHTTP(t *testing.T) {
+	srv := newInfluxServer(t, http.StatusBadRequest)
+	sk := srv.open(t)
+	batch := []types.Snapshot{testSnapshot(time.Unix(1700000000, 0), 2), testSnapshot(time.Unix(1700000010, 0), 2)}
+
+	if err := sk.Write(context.Background(), batch); !errors.Is(err, ErrRejected) {
+		t.Errorf("400: %v, want ErrRejected", err)
+	}
+	if err := sk.Write(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	WriteLines(&want, batch)
+	if srv.bodies[1] != want.String() {
+		t.Errorf("body =\n%s\nwant\n%s", srv.bodies[1], want.String())
+	}
+	if srv.auth[1] != "Token s3cret" {
+		t.Errorf("Authorization = %q", srv.auth[1])
+	}
+}
+
+func TestBatcherDropsRejected(t *testing.T) {
+	srv := newInfluxServer(t, http.StatusBadRequest)
+	b := NewBatcher("influx", srv.open(t), Options{BatchSize: 1})
+	b.Enqueue(testSnapshot(time.Unix(1700000000, 0), 1))
+	b.Enqueue(testSnapshot(time.Unix(1700000010, 0), 1))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := b.Stats(); st.Sent != 1 || st.Dropped != 1 || st.Errors != 1 || srv.requests() != 2 {
+		t.Errorf("stats %+v after %d requests; want the rejected batch dropped, not retried", st, srv.requests())
+	}
+}
+
+func TestBatcherRetries(t *testing.T) {
+	srv := newInfluxServer(t, http.StatusServiceUnavailable)
+	b := NewBatcher("influx", srv.open(t), Options{BatchSize: 1, FlushInterval: time.Hour})
+	b.Enqueue(testSnapshot(time.Unix(1700000000, 0), 1))
+	deadline := time.Now().Add(5 * time.Second)
+	for b.Stats().Sent == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.Close()
+	st := b.Stats()
+	if st.Sent != 1 || st.Errors != 1 || st.Dropped != 0 || srv.requests() != 2 {
+		t.Fatalf("stats %+v after %d requests; want one retry after the 503", st, srv.requests())
+	}
+	if srv.bodies[0] != srv.bodies[1] {
+		t.Error("the retry sent a different batch")
+	}
+}
+
+func TestInfluxUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	sk, err := Open("influx+udp://"+pc.LocalAddr().String(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sk.Close()
+	var batch []types.Snapshot
+	for i := 0; i < 10; i++ {
+		batch = append(batch, testSnapshot(time.Unix(1700000000+int64(i), 0), 8))
+	}
+	var want bytes.Buffer
+	WriteLines(&want, batch)
+	if err := sk.Write(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+
+	var got bytes.Buffer
+	buf := make([]byte, 64*1024)
+	for got.Len() < want.Len() {
+		pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("after %d of %d bytes: %v", got.Len(), want.Len(), err)
+		}
+		if n > maxDatagram || !strings.HasSuffix(string(buf[:n]), "\n") {
+			t.Errorf("datagram of %d bytes, want at most %d whole lines", n, maxDatagram)
+		}
+		got.Write(buf[:n])
+	}
+	if got.String() != want.String() {
+		t.Error("datagrams do not add up to the batch")
+	}
+}
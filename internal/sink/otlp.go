@@ -0,0 +1,377 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gpuwatch/internal/types"
+)
+
+func init() {
+	Register("otlp+http", openOTLP)
+	Register("otlp+https", openOTLP)
+}
+
+// OTLP encodings, named as in OTEL_EXPORTER_OTLP_PROTOCOL.
+const (
+	OTLPProtobuf = "http/protobuf"
+	OTLPJSON     = "http/json"
+)
+
+// OTLPScope names the instrumentation scope of exported metrics; Version is
+// set by the binary.
+var OTLPScope = struct{ Name, Version string }{Name: "gpuwatch"}
+
+// otlpHTTP posts metrics to an OTLP/HTTP receiver, by default
+// <endpoint>/v1/metrics. Every host is one resource (host.name, host.id and
+// the host labels) carrying the per-user metrics, and every GPU another one
+// with the device attributes added, carrying the GPU and per-process
+// metrics.
+type otlpHTTP struct {
+	url      string
+	headers  map[string]string
+	protocol string
+	client   *http.Client
+}
+
+func openOTLP(u *url.URL, opts Options) (Sink, error) {
+	protocol := opts.Protocol
+	switch protocol {
+	case "":
+		protocol = OTLPProtobuf
+	case OTLPProtobuf, OTLPJSON:
+	case "grpc":
+		return nil, errors.New("OTLP/gRPC is not supported; use the OTLP/HTTP receiver (port 4318 on the collector)")
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q (want %s or %s)", protocol, OTLPProtobuf, OTLPJSON)
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host, e.g. otlp+http://collector:4318")
+	}
+	w := *u
+	w.Scheme = strings.TrimPrefix(u.Scheme, "otlp+")
+	if w.Path == "" || w.Path == "/" {
+		w.Path = "/v1/metrics"
+	}
+	return &otlpHTTP{url: w.String(), headers: opts.Headers, protocol: protocol, client: &http.Client{}}, nil
+}
+
+func (s *otlpHTTP) Write(ctx context.Context, batch []types.Snapshot) error {
+	req := buildOTLP(batch)
+	var body []byte
+	contentType := "application/x-protobuf"
+	if s.protocol == OTLPJSON {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	} else {
+		body = req.marshal()
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.headers {
+		r.Header.Set(k, v)
+	}
+	r.Header.Set("Content-Type", contentType)
+	resp, err := s.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		return fmt.Errorf("otlp: %s", resp.Status)
+	case resp.StatusCode/100 == 4:
+		// Retrying a 4xx other than 429 cannot succeed (OTLP/HTTP spec).
+		return fmt.Errorf("otlp: %w: %s: %s", ErrRejected, resp.Status, printable(msg))
+	default:
+		return fmt.Errorf("otlp: %s: %s", resp.Status, printable(msg))
+	}
+}
+
+func (s *otlpHTTP) Close() error { return nil }
+
+// printable trims a response body for an error; protobuf Status bodies are
+// left out.
+func printable(b []byte) string {
+	b = bytes.TrimSpace(b)
+	for _, c := range b {
+		if c < 0x20 && c != '\n' && c != '\t' {
+			return ""
+		}
+	}
+	return string(b)
+}
+
+// The OTLP metrics data model, as far as gpuwatch uses it. JSON field names
+// follow the OTLP/JSON mapping; marshal writes the protobuf encoding.
+type (
+	otlpRequest struct {
+		ResourceMetrics []*otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource        `json:"resource"`
+		ScopeMetrics []*otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope     `json:"scope"`
+		Metrics []*otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	otlpMetric struct {
+		Name        string    `json:"name"`
+		Description string    `json:"description,omitempty"`
+		Unit        string    `json:"unit,omitempty"`
+		Gauge       otlpGauge `json:"gauge"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+	otlpDataPoint struct {
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+		TimeUnixNano uint64         `json:"timeUnixNano,string"`
+		AsDouble     float64        `json:"asDouble"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *int64  `json:"intValue,omitempty,string"`
+	}
+)
+
+func str(k, v string) otlpKeyValue { return otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: &v}} }
+
+func num(k string, v int64) otlpKeyValue {
+	return otlpKeyValue{Key: k, Value: otlpAnyValue{IntValue: &v}}
+}
+
+const mib = 1024 * 1024
+
+// otlpMetrics describes every exported instrument; all are gauges.
+var otlpMetrics = map[string]struct{ desc, unit string }{
+	"hw.gpu.utilization":                {"GPU utilization.", "1"},
+	"hw.gpu.memory.utilization":         {"GPU memory controller utilization.", "1"},
+	"hw.gpu.memory.usage":               {"GPU memory in use.", "By"},
+	"hw.gpu.memory.limit":               {"GPU memory size.", "By"},
+	"hw.temperature":                    {"GPU temperature.", "Cel"},
+	"hw.power":                          {"GPU power draw.", "W"},
+	"gpuwatch.gpu.power.limit":          {"GPU power limit.", "W"},
+	"gpuwatch.process.gpu.memory.usage": {"GPU memory used by a process.", "By"},
+	"gpuwatch.user.gpu.memory.usage":    {"GPU memory used by all processes of a user on the host.", "By"},
+	"gpuwatch.user.gpu.processes":       {"Number of GPU processes of a user on the host.", "{process}"},
+}
+
+// buildOTLP maps snapshots to resources and gauge data points, one data
+// point per snapshot.
+func buildOTLP(snaps []types.Snapshot) *otlpRequest {
+	req := &otlpRequest{}
+	resources := make(map[string]*otlpResourceMetrics)
+	resourceFor := func(key string, attrs []otlpKeyValue) *otlpScopeMetrics {
+		rm, ok := resources[key]
+		if !ok {
+			rm = &otlpResourceMetrics{Resource: otlpResource{Attributes: attrs},
+				ScopeMetrics: []*otlpScopeMetrics{{Scope: otlpScope{Name: OTLPScope.Name, Version: OTLPScope.Version}}}}
+			resources[key] = rm
+			req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		}
+		return rm.ScopeMetrics[0]
+	}
+	add := func(sm *otlpScopeMetrics, name string, ts uint64, v float64, attrs ...otlpKeyValue) {
+		var m *otlpMetric
+		for _, have := range sm.Metrics {
+			if have.Name == name {
+				m = have
+				break
+			}
+		}
+		if m == nil {
+			info := otlpMetrics[name]
+			m = &otlpMetric{Name: name, Description: info.desc, Unit: info.unit}
+			sm.Metrics = append(sm.Metrics, m)
+		}
+		m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpDataPoint{Attributes: attrs, TimeUnixNano: ts, AsDouble: v})
+	}
+
+	for _, s := range snaps {
+		ts := uint64(s.TS.UnixNano())
+		host := hostResource(s.Host)
+		hostKey := s.Host.Hostname + "\x00" + s.Host.MachineID
+		gpus := make(map[string]*otlpScopeMetrics, len(s.GPUs))
+		for _, g := range s.GPUs {
+			attrs := append(append([]otlpKeyValue{}, host...),
+				str("hw.id", g.UUID), str("hw.name", g.Name), str("hw.type", "gpu"), num("gpu.index", int64(g.Index)))
+			sm := resourceFor(hostKey+"\x00"+g.UUID, attrs)
+			gpus[g.UUID] = sm
+			add(sm, "hw.gpu.utilization", ts, g.UtilGPU/100)
+			add(sm, "hw.gpu.memory.utilization", ts, g.UtilMem/100)
+			add(sm, "hw.gpu.memory.usage", ts, g.MemUsedMB*mib)
+			add(sm, "hw.gpu.memory.limit", ts, g.MemTotalMB*mib)
+			add(sm, "hw.temperature", ts, g.TempC)
+			add(sm, "hw.power", ts, g.PowerDrawW)
+			add(sm, "gpuwatch.gpu.power.limit", ts, g.PowerLimitW)
+		}
+		mem := make(map[string]float64)
+		procs := make(map[string]int)
+		for _, p := range s.Procs {
+			mem[p.User] += p.UsedMemMB
+			procs[p.User]++
+			sm, ok := gpus[p.GPUUUID]
+			if !ok {
+				continue
+			}
+			add(sm, "gpuwatch.process.gpu.memory.usage", ts, p.UsedMemMB*mib,
+				num("process.pid", int64(p.PID)), str("process.owner", p.User), str("process.executable.name", p.ProcessName))
+		}
+		if len(mem) == 0 {
+			continue
+		}
+		sm := resourceFor(hostKey, host)
+		users := make([]string, 0, len(mem))
+		for u := range mem {
+			users = append(users, u)
+		}
+		sort.Strings(users)
+		for _, u := range users {
+			add(sm, "gpuwatch.user.gpu.memory.usage", ts, mem[u]*mib, str("user.name", u))
+			add(sm, "gpuwatch.user.gpu.processes", ts, float64(procs[u]), str("user.name", u))
+		}
+	}
+	return req
+}
+
+// hostResource is service.name, host.name, host.id and the host labels.
+func hostResource(h types.Host) []otlpKeyValue {
+	attrs := []otlpKeyValue{str("service.name", "gpuwatch"), str("host.name", h.Hostname)}
+	if h.MachineID != "" {
+		attrs = append(attrs, str("host.id", h.MachineID))
+	}
+	keys := make([]string, 0, len(h.Labels))
+	for k := range h.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch k {
+		case "service.name", "host.name", "host.id":
+			continue
+		}
+		attrs = append(attrs, str(k, h.Labels[k]))
+	}
+	return attrs
+}
+
+// protobuf field numbers from opentelemetry/proto/metrics/v1/metrics.proto
+// and common/v1/common.proto.
+func (r *otlpRequest) marshal() []byte {
+	var e pbEncoder
+	for _, rm := range r.ResourceMetrics {
+		e.message(1, func(e *pbEncoder) { // ExportMetricsServiceRequest.resource_metrics
+			e.message(1, func(e *pbEncoder) { // ResourceMetrics.resource
+				for _, kv := range rm.Resource.Attributes {
+					e.keyValue(1, kv) // Resource.attributes
+				}
+			})
+			for _, sm := range rm.ScopeMetrics {
+				e.message(2, func(e *pbEncoder) { // ResourceMetrics.scope_metrics
+					e.message(1, func(e *pbEncoder) { // ScopeMetrics.scope
+						e.string(1, sm.Scope.Name)
+						e.string(2, sm.Scope.Version)
+					})
+					for _, m := range sm.Metrics {
+						e.message(2, func(e *pbEncoder) { // ScopeMetrics.metrics
+							e.string(1, m.Name)
+							e.string(2, m.Description)
+							e.string(3, m.Unit)
+							e.message(5, func(e *pbEncoder) { // Metric.gauge
+								for _, dp := range m.Gauge.DataPoints {
+									e.message(1, func(e *pbEncoder) { // Gauge.data_points
+										e.fixed64(3, dp.TimeUnixNano)               // time_unix_nano
+										e.fixed64(4, math.Float64bits(dp.AsDouble)) // as_double
+										for _, kv := range dp.Attributes {
+											e.keyValue(7, kv)
+										}
+									})
+								}
+							})
+						})
+					}
+				})
+			}
+		})
+	}
+	return e.b
+}
+
+// pbEncoder writes the protobuf wire format.
+type pbEncoder struct{ b []byte }
+
+func (e *pbEncoder) tag(field, wire int) {
+	e.b = binary.AppendUvarint(e.b, uint64(field)<<3|uint64(wire))
+}
+
+func (e *pbEncoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.tag(field, 2)
+	e.b = binary.AppendUvarint(e.b, uint64(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *pbEncoder) fixed64(field int, v uint64) {
+	e.tag(field, 1)
+	e.b = binary.LittleEndian.AppendUint64(e.b, v)
+}
+
+func (e *pbEncoder) message(field int, fn func(*pbEncoder)) {
+	var sub pbEncoder
+	fn(&sub)
+	e.tag(field, 2)
+	e.b = binary.AppendUvarint(e.b, uint64(len(sub.b)))
+	e.b = append(e.b, sub.b...)
+}
+
+// keyValue writes a KeyValue with a string_value (1) or int_value (3).
+func (e *pbEncoder) keyValue(field int, kv otlpKeyValue) {
+	e.message(field, func(e *pbEncoder) {
+		e.string(1, kv.Key)
+		e.message(2, func(e *pbEncoder) {
+			switch {
+			case kv.Value.StringValue != nil:
+				e.tag(1, 2)
+				e.b = binary.AppendUvarint(e.b, uint64(len(*kv.Value.StringValue)))
+				e.b = append(e.b, *kv.Value.StringValue...)
+			case kv.Value.IntValue != nil:
+				e.tag(3, 0)
+				e.b = binary.AppendUvarint(e.b, uint64(*kv.Value.IntValue))
+			}
+		})
+	})
+}
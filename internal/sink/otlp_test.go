@@ -0,0 +1,404 @@
+package sink
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// pbField is one decoded protobuf field: a varint, a fixed64 or the bytes
+// of a length-delimited field.
+type pbField struct {
+	num   int
+	wire  int
+	v     uint64
+	bytes []byte
+}
+
+// pbFields splits a protobuf message into its fields.
+func pbFields(t *testing.T, b []byte) []pbField {
+	t.Helper()
+	var out []pbField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad field key in % x", b)
+		}
+		b = b[n:]
+		f := pbField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case 0:
+			f.v, n = binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatalf("field %d: bad varint", f.num)
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				t.Fatalf("field %d: short fixed64", f.num)
+			}
+			f.v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				t.Fatalf("field %d: bad length", f.num)
+			}
+			f.bytes, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			t.Fatalf("field %d: unexpected wire type %d", f.num, f.wire)
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// want checks the wire type of a field of a message.
+func (f pbField) want(t *testing.T, msg string, wire int) pbField {
+	t.Helper()
+	if f.wire != wire {
+		t.Fatalf("%s field %d: wire type %d, want %d", msg, f.num, f.wire, wire)
+	}
+	return f
+}
+
+// decodeOTLP decodes an ExportMetricsServiceRequest with the field numbers
+// of opentelemetry/proto, independently of marshal.
+func decodeOTLP(t *testing.T, b []byte) *otlpRequest {
+	req := &otlpRequest{}
+	for _, f := range pbFields(t, b) {
+		if f.num != 1 {
+			t.Fatalf("ExportMetricsServiceRequest: unknown field %d", f.num)
+		}
+		rm := &otlpResourceMetrics{}
+		for _, f := range pbFields(t, f.want(t, "request", 2).bytes) {
+			switch f.num {
+			case 1: // resource
+				for _, f := range pbFields(t, f.want(t, "ResourceMetrics", 2).bytes) {
+					if f.num != 1 {
+						t.Fatalf("Resource: unknown field %d", f.num)
+					}
+					rm.Resource.Attributes = append(rm.Resource.Attributes, decodeKeyValue(t, f.want(t, "Resource", 2).bytes))
+				}
+			case 2: // scope_metrics
+				rm.ScopeMetrics = append(rm.ScopeMetrics, decodeScopeMetrics(t, f.want(t, "ResourceMetrics", 2).bytes))
+			default:
+				t.Fatalf("ResourceMetrics: unknown field %d", f.num)
+			}
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+	}
+	return req
+}
+
+func decodeScopeMetrics(t *testing.T, b []byte) *otlpScopeMetrics {
+	sm := &otlpScopeMetrics{}
+	for _, f := range pbFields(t, b) {
+		switch f.num {
+		case 1: // scope
+			for _, f := range pbFields(t, f.want(t, "ScopeMetrics", 2).bytes) {
+				switch f.num {
+				case 1:
+					sm.Scope.Name = string(f.want(t, "InstrumentationScope", 2).bytes)
+				case 2:
+					sm.Scope.Version = string(f.want(t, "InstrumentationScope", 2).bytes)
+				default:
+					t.Fatalf("InstrumentationScope: unknown field %d", f.num)
+				}
+			}
+		case 2: // metrics
+			m := &otlpMetric{}
+			for _, f := range pbFields(t, f.want(t, "ScopeMetrics", 2).bytes) {
+				switch f.num {
+				case 1:
+					m.Name = string(f.want(t, "Metric", 2).bytes)
+				case 2:
+					m.Description = string(f.want(t, "Metric", 2).bytes)
+				case 3:
+					m.Unit = string(f.want(t, "Metric", 2).bytes)
+				case 5: // gauge
+					for _, f := range pbFields(t, f.want(t, "Metric", 2).bytes) {
+						if f.num != 1 {
+							t.Fatalf("Gauge: unknown field %d", f.num)
+						}
+						m.Gauge.DataPoints = append(m.Gauge.DataPoints, decodeDataPoint(t, f.want(t, "Gauge", 2).bytes))
+					}
+				default:
+					t.Fatalf("Metric: unexpected field %d", f.num)
+				}
+			}
+			sm.Metrics = append(sm.Metrics, m)
+		default:
+			t.Fatalf("ScopeMetrics: unknown field %d", f.num)
+		}
+	}
+	return sm
+}
+
+func decodeDataPoint(t *testing.T, b []byte) otlpDataPoint {
+	var dp otlpDataPoint
+	for _, f := range pbFields(t, b) {
+		switch f.num {
+		case 3: // time_unix_nano
+			dp.TimeUnixNano = f.want(t, "NumberDataPoint", 1).v
+		case 4: // as_double
+			dp.AsDouble = math.Float64frombits(f.want(t, "NumberDataPoint", 1).v)
+		case 7: // attributes
+			dp.Attributes = append(dp.Attributes, decodeKeyValue(t, f.want(t, "NumberDataPoint", 2).bytes))
+		default:
+			t.Fatalf("NumberDataPoint: unexpected field %d", f.num)
+		}
+	}
+	return dp
+}
+
+func decodeKeyValue(t *testing.T, b []byte) otlpKeyValue {
+	var kv otlpKeyValue
+	for _, f := range pbFields(t, b) {
+		switch f.num {
+		case 1:
+			kv.Key = string(f.want(t, "KeyValue", 2).bytes)
+		case 2: // AnyValue
+			for _, f := range pbFields(t, f.want(t, "KeyValue", 2).bytes) {
+				switch f.num {
+				case 1:
+					s := string(f.want(t, "AnyValue", 2).bytes)
+					kv.Value.StringValue = &s
+				case 3:
+					v := int64(f.want(t, "AnyValue", 0).v)
+					kv.Value.IntValue = &v
+				default:
+					t.Fatalf("AnyValue: unexpected field %d", f.num)
+				}
+			}
+		default:
+			t.Fatalf("KeyValue: unknown field %d", f.num)
+		}
+	}
+	return kv
+}
+
+// otlpServer records the requests to a stand-in OTLP/HTTP receiver and
+// answers with code.
+type otlpServer struct {
+	*httptest.Server
+	code int
+	reqs []*http.Request
+	body [][]byte
+}
+
+func newOTLPServer(t *testing.T) *otlpServer {
+	s := &otlpServer{code: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		s.reqs = append(s.reqs, r)
+		s.body = append(s.body, b)
+		w.WriteHeader(s.code)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func otlpBatch() []types.Snapshot {
+	var batch []types.Snapshot
+	for _, ts := range []time.Time{time.Unix(1700000000, 5), time.Unix(1700000010, 0)} {
+		s := testSnapshot(ts, 2)
+		s.Host.MachineID = "m-node1"
+		batch = append(batch, s)
+	}
+	return batch
+}
+
+// attrs returns the string and int attributes of kvs.
+func attrs(kvs []otlpKeyValue) map[string]any {
+	out := make(map[string]any)
+	for _, kv := range kvs {
+		switch {
+		case kv.Value.StringValue != nil:
+			out[kv.Key] = *kv.Value.StringValue
+		case kv.Value.IntValue != nil:
+			out[kv.Key] = *kv.Value.IntValue
+		}
+	}
+	return out
+}
+
+func TestOTLPProtobuf(t *testing.T) {
+	srv := newOTLPServer(t)
+	sk, err := Open("otlp+"+srv.URL, Options{Headers: map[string]string{"api-key": "s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := otlpBatch()
+	if err := sk.Write(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	r := srv.reqs[0]
+	if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("api-key") != "s3cret" {
+		t.Errorf("request to %s, Content-Type %q, api-key %q", r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("api-key"))
+	}
+
+	got := decodeOTLP(t, srv.body[0])
+	if want := buildOTLP(batch); !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded request differs from the one encoded:\n%+v\nwant\n%+v", got, want)
+	}
+	// Two snapshots of one host: a resource per GPU, then the host with the
+	// per-user metrics.
+	if len(got.ResourceMetrics) != 3 {
+		t.Fatalf("%d resources, want 3", len(got.ResourceMetrics))
+	}
+	gpu := attrs(got.ResourceMetrics[1].Resource.Attributes)
+	wantGPU := map[string]any{"service.name": "gpuwatch", "host.name": "node1", "host.id": "m-node1", "rack": "r 2",
+		"hw.id": "GPU-1", "hw.name": "NVIDIA A100", "hw.type": "gpu", "gpu.index": int64(1)}
+	if !reflect.DeepEqual(gpu, wantGPU) {
+		t.Errorf("GPU resource = %v, want %v", gpu, wantGPU)
+	}
+	if host := attrs(got.ResourceMetrics[2].Resource.Attributes); host["host.name"] != "node1" || host["hw.id"] != nil {
+		t.Errorf("host resource = %v", host)
+	}
+
+	sm := got.ResourceMetrics[0].ScopeMetrics[0]
+	if sm.Scope.Name != "gpuwatch" {
+		t.Errorf("scope %+v", sm.Scope)
+	}
+	var names []string
+	for _, m := range sm.Metrics {
+		names = append(names, m.Name)
+	}
+	wantNames := []string{"hw.gpu.utilization", "hw.gpu.memory.utilization", "hw.gpu.memory.usage", "hw.gpu.memory.limit",
+		"hw.temperature", "hw.power", "gpuwatch.gpu.power.limit", "gpuwatch.process.gpu.memory.usage"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("GPU metrics %v, want %v", names, wantNames)
+	}
+	util := sm.Metrics[0]
+	if util.Unit != "1" || len(util.Gauge.DataPoints) != 2 {
+		t.Fatalf("utilization = %+v", util)
+	}
+	if dp := util.Gauge.DataPoints[0]; dp.AsDouble != 0.87 || dp.TimeUnixNano != 1700000000000000005 {
+		t.Errorf("utilization point = %+v, want 0.87 at 1700000000000000005", dp)
+	}
+	proc := sm.Metrics[7].Gauge.DataPoints[0]
+	if a := attrs(proc.Attributes); proc.AsDouble != 1500*mib || a["process.pid"] != int64(42) || a["process.owner"] != "alice" ||
+		a["process.executable.name"] != `my "job"` {
+		t.Errorf("process point = %v %v", proc.AsDouble, a)
+	}
+	users := got.ResourceMetrics[2].ScopeMetrics[0].Metrics
+	if len(users) != 2 || users[0].Name != "gpuwatch.user.gpu.memory.usage" || users[1].Gauge.DataPoints[0].AsDouble != 2 {
+		t.Errorf("user metrics = %+v", users)
+	}
+}
+
+func TestOTLPJSON(t *testing.T) {
+	srv := newOTLPServer(t)
+	sk, err := Open("otlp+"+srv.URL+"/otlp/v1/metrics", Options{Protocol: OTLPJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := otlpBatch()
+	if err := sk.Write(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if r := srv.reqs[0]; r.URL.Path != "/otlp/v1/metrics" || r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("request to %s, Content-Type %q", r.URL.Path, r.Header.Get("Content-Type"))
+	}
+
+	// The OTLP/JSON mapping: lowerCamelCase names, 64-bit integers as strings.
+	var doc struct {
+		ResourceMetrics []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value map[string]any
+				}
+			}
+			ScopeMetrics []struct {
+				Scope   struct{ Name string }
+				Metrics []struct {
+					Name  string
+					Unit  string
+					Gauge struct {
+						DataPoints []struct {
+							TimeUnixNano any
+							AsDouble     any
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(srv.body[0], &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.ResourceMetrics) != 3 {
+		t.Fatalf("%d resources, want 3:\n%s", len(doc.ResourceMetrics), srv.body[0])
+	}
+	values := make(map[string]any)
+	for _, kv := range doc.ResourceMetrics[0].Resource.Attributes {
+		values[kv.Key] = kv.Value
+	}
+	if !reflect.DeepEqual(values["host.name"], map[string]any{"stringValue": "node1"}) ||
+		!reflect.DeepEqual(values["gpu.index"], map[string]any{"intValue": "0"}) {
+		t.Errorf("resource attributes = %v", values)
+	}
+	m := doc.ResourceMetrics[0].ScopeMetrics[0].Metrics[0]
+	if m.Name != "hw.gpu.utilization" || m.Gauge.DataPoints[0].TimeUnixNano != "1700000000000000005" || m.Gauge.DataPoints[0].AsDouble != 0.87 {
+		t.Errorf("first metric = %+v", m)
+	}
+
+	var got otlpRequest
+	if err := json.Unmarshal(srv.body[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, buildOTLP(batch)) {
+		t.Error("JSON body does not round-trip")
+	}
+}
+
+func TestOTLPStatus(t *testing.T) {
+	srv := newOTLPServer(t)
+	sk, err := Open("otlp+"+srv.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		code     int
+		ok       bool
+		rejected bool
+	}{
+		{code: http.StatusOK, ok: true},
+		{code: http.StatusAccepted, ok: true},
+		{code: http.StatusBadRequest, rejected: true},
+		{code: http.StatusUnauthorized, rejected: true},
+		{code: http.StatusRequestEntityTooLarge, rejected: true},
+		{code: http.StatusTooManyRequests},
+		{code: http.StatusInternalServerError},
+		{code: http.StatusBadGateway},
+		{code: http.StatusServiceUnavailable},
+		{code: http.StatusGatewayTimeout},
+	} {
+		srv.code = c.code
+		err := sk.Write(context.Background(), otlpBatch())
+		switch {
+		case c.ok && err != nil:
+			t.Errorf("%d: %v", c.code, err)
+		case !c.ok && err == nil:
+			t.Errorf("%d: no error", c.code)
+		case !c.ok && errors.Is(err, ErrRejected) != c.rejected:
+			t.Errorf("%d: %v; rejected %v, want %v", c.code, err, errors.Is(err, ErrRejected), c.rejected)
+		}
+	}
+	for _, proto := range []string{"grpc", "http/xml"} {
+		if _, err := Open("otlp+"+srv.URL, Options{Protocol: proto}); err == nil {
+			t.Errorf("protocol %s accepted", proto)
+		}
+	}
+}
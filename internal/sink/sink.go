@@ -0,0 +1,258 @@
+// Package sink writes snapshots to external time-series systems next to the
+// SQLite history. A sink is opened from a URL whose scheme selects the
+// implementation; a Batcher adds batching and retry to any sink.
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// Sink writes batches of snapshots. Write is never called concurrently.
+type Sink interface {
+	Write(ctx context.Context, batch []types.Snapshot) error
+	Close() error
+}
+
+// Options configures a sink and its Batcher.
+type Options struct {
+	Token         string            // Influx API token
+	Headers       map[string]string // OTLP request headers
+	Protocol      string            // OTLP encoding: OTLPProtobuf (default) or OTLPJSON
+	BatchSize     int               // snapshots per write
+	FlushInterval time.Duration     // longest wait before a partial batch is written
+	MaxPending    int               // oldest snapshots are dropped beyond this while a sink is down
+	Timeout       time.Duration     // per write
+}
+
+// Defaults for Options.
+const (
+	DefaultBatchSize     = 16
+	DefaultFlushInterval = 10 * time.Second
+	DefaultMaxPending    = 10000
+	DefaultTimeout       = 10 * time.Second
+)
+
+// ErrRejected marks a batch the receiver refused as invalid; retrying it
+// cannot succeed, so it is dropped.
+var ErrRejected = errors.New("rejected")
+
+type opener func(u *url.URL, opts Options) (Sink, error)
+
+var (
+	mu      sync.Mutex
+	openers = make(map[string]opener)
+)
+
+// Register makes a sink available under a URL scheme.
+func Register(scheme string, open func(u *url.URL, opts Options) (Sink, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	openers[scheme] = open
+}
+
+// Schemes lists the registered URL schemes.
+func Schemes() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var out []string
+	for s := range openers {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Open creates the sink for spec, e.g. graphite://host:2003.
+func Open(spec string, opts Options) (Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	open, ok := openers[u.Scheme]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("sink %s: unknown scheme %q (have %s)", u.Redacted(), u.Scheme, strings.Join(Schemes(), ", "))
+	}
+	s, err := open(u, opts)
+	if err != nil {
+		return nil, fmt.Errorf("sink %s: %w", u.Redacted(), err)
+	}
+	return s, nil
+}
+
+// Stats reports a Batcher's progress.
+type Stats struct {
+	Sent    uint64 // snapshots written
+	Pending int    // snapshots waiting to be written
+	Dropped uint64 // discarded: queue full, too many pending, rejected or left at Close
+	Errors  uint64 // failed writes
+	LastErr error
+}
+
+const (
+	queueSize  = 1024
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Batcher queues snapshots for a sink and writes them in batches from a
+// background goroutine. Failed writes are retried with backoff while new
+// snapshots keep queueing up to MaxPending.
+type Batcher struct {
+	Name  string // for logs
+	sink  Sink
+	opts  Options
+	queue chan types.Snapshot
+	done  chan struct{}
+	err   error // of the final write, set before done is closed
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// NewBatcher starts writing to s.
+func NewBatcher(name string, s Sink, opts Options) *Batcher {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = DefaultMaxPending
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	b := &Batcher{Name: name, sink: s, opts: opts, queue: make(chan types.Snapshot, queueSize), done: make(chan struct{})}
+	go b.run()
+	return b
+}
+
+// Enqueue queues s without blocking; it returns false and counts the
+// snapshot as dropped when the queue is full. It must not be called after
+// Close.
+func (b *Batcher) Enqueue(s types.Snapshot) bool {
+	select {
+	case b.queue <- s:
+		return true
+	default:
+		b.mu.Lock()
+		b.stats.Dropped++
+		b.mu.Unlock()
+		return false
+	}
+}
+
+// Close writes what is pending once more, then closes the sink. It returns
+// the error of that last write, if any.
+func (b *Batcher) Close() error {
+	close(b.queue)
+	<-b.done
+	err := b.err
+	if cerr := b.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Stats returns a copy of the counters.
+func (b *Batcher) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+	var pending []types.Snapshot
+	backoff := time.Duration(0)
+	timer := time.NewTimer(b.opts.FlushInterval)
+	arm := func(d time.Duration) {
+		timer.Stop()
+		timer = time.NewTimer(d)
+	}
+	defer func() { timer.Stop() }()
+
+	for {
+		select {
+		case s, ok := <-b.queue:
+			if !ok {
+				b.err = b.flush(&pending)
+				b.mu.Lock()
+				b.stats.Dropped += uint64(len(pending))
+				b.stats.Pending = 0
+				b.mu.Unlock()
+				return
+			}
+			pending = append(pending, s)
+			if over := len(pending) - b.opts.MaxPending; over > 0 {
+				pending = pending[over:]
+				b.mu.Lock()
+				b.stats.Dropped += uint64(over)
+				b.mu.Unlock()
+			}
+			if backoff > 0 || len(pending) < b.opts.BatchSize {
+				break
+			}
+			if b.flush(&pending) != nil && len(pending) > 0 {
+				backoff = min(max(2*backoff, minBackoff), maxBackoff)
+				arm(backoff)
+			} else {
+				backoff = 0
+				arm(b.opts.FlushInterval)
+			}
+		case <-timer.C:
+			if b.flush(&pending) != nil && len(pending) > 0 {
+				backoff = min(max(2*backoff, minBackoff), maxBackoff)
+				arm(backoff)
+			} else {
+				backoff = 0
+				arm(b.opts.FlushInterval)
+			}
+		}
+		b.mu.Lock()
+		b.stats.Pending = len(pending)
+		b.mu.Unlock()
+	}
+}
+
+// flush writes pending in batches until a write fails. Rejected batches are
+// dropped; the last error is returned.
+func (b *Batcher) flush(pending *[]types.Snapshot) error {
+	var last error
+	for len(*pending) > 0 {
+		n := min(len(*pending), b.opts.BatchSize)
+		ctx, cancel := context.WithTimeout(context.Background(), b.opts.Timeout)
+		err := b.sink.Write(ctx, (*pending)[:n])
+		cancel()
+		b.mu.Lock()
+		if err == nil {
+			b.stats.Sent += uint64(n)
+		} else {
+			b.stats.Errors++
+			b.stats.LastErr = err
+			if errors.Is(err, ErrRejected) {
+				b.stats.Dropped += uint64(n)
+			}
+		}
+		b.mu.Unlock()
+		if err != nil && !errors.Is(err, ErrRejected) {
+			return err
+		}
+		last = err
+		*pending = (*pending)[n:]
+	}
+	*pending = nil
+	return last
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// ArchiveFormat names the portable archive written by ExportArchive: a gzip
+// stream of JSON lines, an archiveHeader followed by one types.Snapshot per
+// line in time order.
+const (
+	ArchiveFormat  = "gpuwatch-archive"
+	ArchiveVersion = 1
+)
+
+type archiveHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Host    string    `json:"host,omitempty"`
+}
+
+// ExportArchive streams the snapshots of src in [from, to) for host ("" = all)
+// to w and returns how many were written.
+func ExportArchive(w io.Writer, src Store, from, to time.Time, host string) (int, error) {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	hdr := archiveHeader{Format: ArchiveFormat, Version: ArchiveVersion, Created: time.Now().UTC(), From: from.UTC(), To: to.UTC(), Host: host}
+	if err := enc.Encode(hdr); err != nil {
+		return 0, err
+	}
+	n := 0
+	err := src.WalkRange(from, to, host, func(s types.Snapshot) error {
+		s.ID = 0
+		s.TS = s.TS.UTC()
+		n++
+		return enc.Encode(s)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, zw.Close()
+}
+
+// ImportArchive merges an archive written by ExportArchive into dst like
+// Merge, skipping snapshots dst already holds. The whole archive is read and
+// validated before anything is imported, so a damaged or truncated archive
+// leaves dst unchanged; the first invalid line is reported by number.
+func ImportArchive(dst Store, r io.ReadSeeker, fallback types.Host) (imported, skipped int, err error) {
+	if err := readArchive(r, func(types.Snapshot) error { return nil }); err != nil {
+		return 0, 0, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	err = readArchive(r, func(s types.Snapshot) error {
+		if s.Host.Hostname == "" && s.Host.MachineID == "" {
+			s.Host = fallback
+		}
+		_, inserted, err := dst.ImportSnapshot(s)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			imported++
+		} else {
+			skipped++
+		}
+		return nil
+	})
+	return imported, skipped, err
+}
+
+// readArchive checks the header of an archive and calls fn with every
+// snapshot that passes Validate, in order; decoding, validation and fn
+// errors are prefixed with their line number.
+func readArchive(r io.Reader, fn func(s types.Snapshot) error) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("not a gpuwatch archive: %w", err)
+	}
+	defer zr.Close()
+	sc := bufio.NewScanner(zr)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("empty archive")
+	}
+	var hdr archiveHeader
+	if err := json.Unmarshal(sc.Bytes(), &hdr); err != nil || hdr.Format != ArchiveFormat {
+		return fmt.Errorf("not a gpuwatch archive")
+	}
+	if hdr.Version > ArchiveVersion {
+		return fmt.Errorf("archive version %d is newer than supported version %d", hdr.Version, ArchiveVersion)
+	}
+
+	for line := 2; sc.Scan(); line++ {
+		var s types.Snapshot
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := Validate(s); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		s.ID = 0
+		if err := fn(s); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return sc.Err()
+}
+
+// Validate rejects snapshots that could not have been sampled, such as
+// archive lines or pushed snapshots that were corrupted or forged.
+func Validate(s types.Snapshot) error {
+	if s.TS.IsZero() || s.TS.Before(time.Unix(0, 0)) {
+		return fmt.Errorf("missing or invalid timestamp")
+	}
+	if s.Duration < 0 {
+		return fmt.Errorf("negative sample duration")
+	}
+	uuids := make(map[string]bool, len(s.GPUs))
+	for _, g := range s.GPUs {
+		if g.UUID == "" {
+			return fmt.Errorf("GPU %d has no UUID", g.Index)
+		}
+		if uuids[g.UUID] {
+			return fmt.Errorf("duplicate GPU %s", g.UUID)
+		}
+		uuids[g.UUID] = true
+		if g.MemUsedMB < 0 || g.MemTotalMB < 0 || g.PowerDrawW < 0 {
+			return fmt.Errorf("GPU %s has negative readings", g.UUID)
+		}
+	}
+	for _, p := range s.Procs {
+		if p.PID <= 0 {
+			return fmt.Errorf("process with invalid PID %d", p.PID)
+		}
+		if p.UsedMemMB < 0 {
+			return fmt.Errorf("process %d has negative memory", p.PID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// testArchive exports two snapshots of one host and returns the archive
+// with extra appended to its uncompressed lines.
+func testArchive(t *testing.T, t0 time.Time, extra string) []byte {
+	t.Helper()
+	src, err := Open(filepath.Join(t.TempDir(), "src.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := src.SaveSnapshot(testSnapshot("alpha", "m-alpha", t0.Add(time.Duration(i)*time.Minute), nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := ExportArchive(&buf, src, t0, t0.Add(time.Hour), ""); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	zw := gzip.NewWriter(&out)
+	zw.Write(lines)
+	zw.Write([]byte(extra))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestImportArchive(t *testing.T) {
+	t0 := time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+	dst, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	imported, skipped, err := ImportArchive(dst, bytes.NewReader(testArchive(t, t0, "")), types.Host{})
+	if err != nil || imported != 2 || skipped != 0 {
+		t.Fatalf("ImportArchive = %d, %d, %v; want 2 imported", imported, skipped, err)
+	}
+	imported, skipped, err = ImportArchive(dst, bytes.NewReader(testArchive(t, t0, "")), types.Host{})
+	if err != nil || imported != 0 || skipped != 2 {
+		t.Fatalf("ImportArchive again = %d, %d, %v; want 2 skipped", imported, skipped, err)
+	}
+}
+
+func TestImportArchiveBadLastLine(t *testing.T) {
+	t0 := time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+	bad := testSnapshot("alpha", "m-alpha", t0.Add(time.Hour), nil)
+	bad.GPUs[0].PowerDrawW = -1 // fails Validate
+	invalid, err := json.Marshal(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, extra := range map[string]string{
+		"truncated JSON": `{"TS":"2026-03-10T12:00:00Z","GPUs":[`,
+		"invalid value":  string(invalid),
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst, err := Open(filepath.Join(t.TempDir(), "history.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dst.Close()
+
+			imported, _, err := ImportArchive(dst, bytes.NewReader(testArchive(t, t0, extra+"\n")), types.Host{})
+			if err == nil || !strings.HasPrefix(err.Error(), "line 4: ") {
+				t.Fatalf("ImportArchive error = %v, want one for line 4", err)
+			}
+			if imported != 0 {
+				t.Errorf("imported %d snapshots before the bad line", imported)
+			}
+			if _, err := dst.LoadLatest(""); !errors.Is(err, ErrNoSnapshots) {
+				t.Errorf("destination after a failed import: %v, want ErrNoSnapshots", err)
+			}
+		})
+	}
+}
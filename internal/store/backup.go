@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupPages is the number of pages copied per backup step; between steps
+// the source is unlocked so a concurrent writer can commit.
+const backupPages = 1024
+
+// Backup writes a consistent copy of the database to dest using SQLite's
+// online backup API, so it is safe while another process keeps writing.
+// dest must not exist yet and is removed again if the backup fails.
+func (db *DB) Backup(dest string) (err error) {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	dstDB, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dstDB.Close()
+		if err != nil {
+			_ = os.Remove(dest)
+		}
+	}()
+
+	ctx := context.Background()
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dc any) error {
+		return srcConn.Raw(func(sc any) error {
+			d, ok1 := dc.(*sqlite3.SQLiteConn)
+			s, ok2 := sc.(*sqlite3.SQLiteConn)
+			if !ok1 || !ok2 {
+				return errors.New("backup needs sqlite3 connections")
+			}
+			b, err := d.Backup("main", s, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := b.Step(backupPages)
+				if done {
+					break
+				}
+				if err != nil && !isBusy(err) {
+					b.Close()
+					return err
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// OpenCopy opens a private copy of the SQLite database at path, taken with
+// Backup and migrated to the current schema, for reading databases of any
+// version without changing them. Close removes the copy.
+func OpenCopy(path string) (*DB, error) {
+	src, err := OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	dir, err := os.MkdirTemp("", "gpuwatch-")
+	if err != nil {
+		return nil, err
+	}
+	cp := filepath.Join(dir, filepath.Base(path))
+	if err := src.Backup(cp); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	db, err := Open(cp)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	db.copyDir = dir
+	return db, nil
+}
+
+// Close closes the database and removes it if it is a copy from OpenCopy.
+func (db *DB) Close() error {
+	err := db.sqlStore.Close()
+	if db.copyDir != "" {
+		os.RemoveAll(db.copyDir)
+	}
+	return err
+}
+
+func isBusy(err error) bool {
+	var se sqlite3.Error
+	return errors.As(err, &se) && (se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked)
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+
+	"gpuwatch/internal/types"
+)
+
+// batchRows bounds rows per multi-row INSERT, keeping the number of bound
+// parameters below SQLite's and PostgreSQL's limits.
+const batchRows = 500
+
+// SaveBatch persists several snapshots in one transaction using a prepared
+// statement per snapshot row and multi-row inserts for GPU and process stats.
+// It returns the new IDs in input order.
+func (db *sqlStore) SaveBatch(snaps []types.Snapshot) ([]int64, error) {
+	if len(snaps) == 0 {
+		return nil, nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	snapStmt, err := tx.Prepare(db.rebind(`INSERT INTO snapshots(ts,host,machine_id,labels,duration_ns) VALUES (?,?,?,?,?) RETURNING id`))
+	if err != nil {
+		return nil, err
+	}
+	defer snapStmt.Close()
+
+	ids := make([]int64, len(snaps))
+	var gpuArgs, procArgs [][]any
+	for i, s := range snaps {
+		if err = snapStmt.QueryRow(tsValue(s.TS), s.Host.Hostname, s.Host.MachineID, encodeLabels(s.Host.Labels), int64(s.Duration)).Scan(&ids[i]); err != nil {
+			return nil, err
+		}
+		for _, g := range s.GPUs {
+			gpuArgs = append(gpuArgs, []any{ids[i], g.Index, g.Name, g.UUID, g.UtilGPU, g.UtilMem, g.MemUsedMB, g.MemTotalMB, g.TempC, g.PowerDrawW, g.PowerLimitW})
+		}
+		for _, p := range s.Procs {
+			procArgs = append(procArgs, []any{ids[i], p.GPUUUID, p.PID, p.ProcessName, p.UsedMemMB, p.User})
+		}
+	}
+	if err = db.insertRows(tx, `INSERT INTO gpu_stats(snapshot_id,gpu_index,name,uuid,util_gpu,util_mem,mem_used_mb,mem_total_mb,temp_c,power_w,power_limit_w) VALUES `, gpuArgs); err != nil {
+		return nil, err
+	}
+	if err = db.insertRows(tx, `INSERT INTO proc_stats(snapshot_id,gpu_uuid,pid,process_name,used_mem_mb,"user") VALUES `, procArgs); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// insertRows runs prefix with one (?,...) group per row, batchRows rows at a time.
+func (db *sqlStore) insertRows(tx *sql.Tx, prefix string, rows [][]any) error {
+	for start := 0; start < len(rows); start += batchRows {
+		end := start + batchRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		chunk := rows[start:end]
+		group := "(" + strings.TrimSuffix(strings.Repeat("?,", len(chunk[0])), ",") + ")"
+		var b strings.Builder
+		b.WriteString(prefix)
+		args := make([]any, 0, len(chunk)*len(chunk[0]))
+		for i, r := range chunk {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(group)
+			args = append(args, r...)
+		}
+		if _, err := tx.Exec(db.rebind(b.String()), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
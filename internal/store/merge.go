@@ -0,0 +1,41 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// Merge copies every snapshot of src into dst, skipping snapshots dst
+// already holds for the same host and timestamp. Snapshots recorded before
+// host identity existed are attributed to fallback.
+func Merge(dst, src Store, fallback types.Host) (imported, skipped int, err error) {
+	err = src.WalkRange(time.Unix(0, 0), maxTime, "", func(s types.Snapshot) error {
+		if s.Host.Hostname == "" && s.Host.MachineID == "" {
+			s.Host = fallback
+		}
+		_, inserted, err := dst.ImportSnapshot(s)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			imported++
+		} else {
+			skipped++
+		}
+		return nil
+	})
+	return imported, skipped, err
+}
+
+// ValidateAll runs Validate on every snapshot of src, so that a damaged
+// backup is refused before anything is merged from it.
+func ValidateAll(src Store) error {
+	return src.WalkRange(time.Unix(0, 0), maxTime, "", func(s types.Snapshot) error {
+		if err := Validate(s); err != nil {
+			return fmt.Errorf("snapshot %d: %w", s.ID, err)
+		}
+		return nil
+	})
+}
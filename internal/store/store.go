@@ -2,8 +2,12 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"os"
+	"strings"
 	"time"
 
 	"gpuwatch/internal/types"
@@ -11,10 +15,62 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-type DB struct{ *sql.DB }
+// Store is the snapshot history used by the TUI, reports and exporters.
+// DB (SQLite) is the reference implementation; Postgres shares its queries.
+// A host argument filters by hostname; "" matches every host.
+type Store interface {
+	SaveSnapshot(s types.Snapshot) (int64, error)
+	ImportSnapshot(s types.Snapshot) (int64, bool, error)
+	ListSnapshotsByDate(day time.Time, host string) ([]SnapshotMeta, error)
+	ListSnapshotsRange(from, to time.Time, host string) ([]SnapshotMeta, error)
+	WalkRange(from, to time.Time, host string, fn func(types.Snapshot) error) error
+	LoadSnapshot(id int64) (types.Snapshot, error)
+	LoadLatest(host string) (types.Snapshot, error)
+	LoadAt(t time.Time, host string) (types.Snapshot, error)
+	ListHosts() ([]types.Host, error)
+	Close() error
+}
+
+// Connect opens a PostgreSQL store for postgres:// DSNs and a SQLite file otherwise.
+func Connect(dsn string) (Store, error) {
+	if isPostgres(dsn) {
+		return OpenPostgres(dsn)
+	}
+	return Open(dsn)
+}
+
+// ConnectReadOnly is Connect for viewers: it never migrates or writes, so it
+// can attach to a history another process is recording.
+func ConnectReadOnly(dsn string) (Store, error) {
+	if isPostgres(dsn) {
+		return OpenPostgresReadOnly(dsn)
+	}
+	return OpenReadOnly(dsn)
+}
+
+func isPostgres(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// busyTimeoutMS is how long SQLite waits for another process's lock before
+// failing with SQLITE_BUSY.
+const busyTimeoutMS = 5000
+
+// sqlStore implements Store over database/sql. Queries are written with
+// ? placeholders and passed through rebind for the target dialect.
+type sqlStore struct {
+	*sql.DB
+	rebind func(string) string
+}
+
+// DB is the SQLite store.
+type DB struct {
+	sqlStore
+	copyDir string // removed by Close; see OpenCopy
+}
 
 func Open(path string) (*DB, error) {
-	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on", path))
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=%d", path, busyTimeoutMS))
 	if err != nil {
 		return nil, err
 	}
@@ -22,10 +78,35 @@ func Open(path string) (*DB, error) {
 		_ = db.Close()
 		return nil, err
 	}
-	return &DB{db}, nil
+	return &DB{sqlStore: sqlStore{DB: db, rebind: func(q string) string { return q }}}, nil
 }
 
-func (db *DB) Close() error { return db.DB.Close() }
+// OpenReadOnly opens an existing database with mode=ro. The schema must be
+// current; opening it once read-write (e.g. by the recorder) migrates it.
+func OpenReadOnly(path string) (*DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=%d", path, busyTimeoutMS))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &DB{sqlStore: sqlStore{DB: db, rebind: func(q string) string { return q }}}, nil
+}
+
+func (db *sqlStore) Close() error { return db.DB.Close() }
+
+func (db *sqlStore) query(q string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.rebind(q), args...)
+}
+
+func (db *sqlStore) queryRow(q string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.rebind(q), args...)
+}
 
 func migrate(db *sql.DB) error {
 	stmts := []string{
@@ -61,65 +142,173 @@ func migrate(db *sql.DB) error {
 			return err
 		}
 	}
-	return nil
+	for _, c := range hostColumns {
+		if err := addColumnSQLite(db, "snapshots", c[0], c[1]); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_snapshots_host_ts ON snapshots(host, ts);`); err != nil {
+		return err
+	}
+	return migrateTimestamps(db)
+}
+
+// hostColumns are added to snapshots by migration; rows recorded before
+// they existed keep empty values.
+var hostColumns = [][2]string{
+	{"host", "TEXT NOT NULL DEFAULT ''"},
+	{"machine_id", "TEXT NOT NULL DEFAULT ''"},
+	{"labels", "TEXT NOT NULL DEFAULT ''"},       // JSON object
+	{"duration_ns", "BIGINT NOT NULL DEFAULT 0"}, // how long the sample took
+}
+
+// snapshots.ts holds Unix nanoseconds, so stored times carry no zone. Older versions stored Unix seconds;
+// no nanosecond value after 1973 is below secondsCutoff, so smaller values
+// are scaled up once by migrateTimestamps.
+const secondsCutoff = 100_000_000_000
+
+// maxTime is the latest instant representable in snapshots.ts.
+var maxTime = time.Unix(0, math.MaxInt64)
+
+func migrateTimestamps(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`UPDATE snapshots SET ts = ts * 1000000000 WHERE ts < %d`, secondsCutoff))
+	return err
+}
+
+func tsValue(t time.Time) int64 { return t.UnixNano() }
+
+// fromTS returns stored times in UTC; callers convert to the display zone.
+func fromTS(v int64) time.Time { return time.Unix(0, v).UTC() }
+
+func addColumnSQLite(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + decl)
+	return err
+}
+
+func encodeLabels(l map[string]string) string {
+	if len(l) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(l)
+	return string(b)
+}
+
+func decodeLabels(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	var l map[string]string
+	_ = json.Unmarshal([]byte(s), &l)
+	return l
 }
 
 // SaveSnapshot persists a full snapshot and returns its ID.
-func (db *DB) SaveSnapshot(s types.Snapshot) (int64, error) {
+func (db *sqlStore) SaveSnapshot(s types.Snapshot) (int64, error) {
 	tx, err := db.Begin()
 	if err != nil { return 0, err }
 	defer func(){ if err != nil { _ = tx.Rollback() } }()
 
-	res, err := tx.Exec(`INSERT INTO snapshots(ts) VALUES (?)`, s.TS.Unix())
-	if err != nil { return 0, err }
-	id, err := res.LastInsertId()
+	var id int64
+	err = tx.QueryRow(db.rebind(`INSERT INTO snapshots(ts,host,machine_id,labels,duration_ns) VALUES (?,?,?,?,?) RETURNING id`),
+		tsValue(s.TS), s.Host.Hostname, s.Host.MachineID, encodeLabels(s.Host.Labels), int64(s.Duration)).Scan(&id)
 	if err != nil { return 0, err }
 
 	for _, g := range s.GPUs {
-		_, err = tx.Exec(`INSERT INTO gpu_stats(snapshot_id,gpu_index,name,uuid,util_gpu,util_mem,mem_used_mb,mem_total_mb,temp_c,power_w,power_limit_w)
-			VALUES(?,?,?,?,?,?,?,?,?,?,?)`, id, g.Index, g.Name, g.UUID, g.UtilGPU, g.UtilMem, g.MemUsedMB, g.MemTotalMB, g.TempC, g.PowerDrawW, g.PowerLimitW)
+		_, err = tx.Exec(db.rebind(`INSERT INTO gpu_stats(snapshot_id,gpu_index,name,uuid,util_gpu,util_mem,mem_used_mb,mem_total_mb,temp_c,power_w,power_limit_w)
+			VALUES(?,?,?,?,?,?,?,?,?,?,?)`), id, g.Index, g.Name, g.UUID, g.UtilGPU, g.UtilMem, g.MemUsedMB, g.MemTotalMB, g.TempC, g.PowerDrawW, g.PowerLimitW)
 		if err != nil { return 0, err }
 	}
 	for _, p := range s.Procs {
-		_, err = tx.Exec(`INSERT INTO proc_stats(snapshot_id,gpu_uuid,pid,process_name,used_mem_mb,user)
-			VALUES(?,?,?,?,?,?)`, id, p.GPUUUID, p.PID, p.ProcessName, p.UsedMemMB, p.User)
+		_, err = tx.Exec(db.rebind(`INSERT INTO proc_stats(snapshot_id,gpu_uuid,pid,process_name,used_mem_mb,"user")
+			VALUES(?,?,?,?,?,?)`), id, p.GPUUUID, p.PID, p.ProcessName, p.UsedMemMB, p.User)
 		if err != nil { return 0, err }
 	}
 	if err = tx.Commit(); err != nil { return 0, err }
 	return id, nil
 }
 
+// ImportSnapshot saves s unless a snapshot of the same host (hostname and
+// machine ID) with the same timestamp exists. It reports whether s was inserted.
+func (db *sqlStore) ImportSnapshot(s types.Snapshot) (int64, bool, error) {
+	var id int64
+	err := db.queryRow(`SELECT id FROM snapshots WHERE host=? AND machine_id=? AND ts=? LIMIT 1`,
+		s.Host.Hostname, s.Host.MachineID, tsValue(s.TS)).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	id, err = db.SaveSnapshot(s)
+	return id, err == nil, err
+}
+
 // SnapshotMeta minimal info for navigation.
 type SnapshotMeta struct {
-	ID int64
-	TS time.Time
+	ID   int64
+	TS   time.Time
+	Host string
 }
 
-// ListSnapshotsByDate returns all snapshot metas for a given local date (00:00-24:00).
-func (db *DB) ListSnapshotsByDate(day time.Time) ([]SnapshotMeta, error) {
+// DayBounds returns midnight of day and of the next day in day's location.
+// The day is 23 or 25 hours long across DST changes.
+func DayBounds(day time.Time) (time.Time, time.Time) {
 	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
-	end := start.Add(24 * time.Hour)
-	rows, err := db.Query(`SELECT id, ts FROM snapshots WHERE ts >= ? AND ts < ? ORDER BY ts ASC`, start.Unix(), end.Unix())
+	return start, time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, day.Location())
+}
+
+// ListSnapshotsByDate returns all snapshot metas for the calendar date of day
+// in day's location, with times in that location.
+func (db *sqlStore) ListSnapshotsByDate(day time.Time, host string) ([]SnapshotMeta, error) {
+	start, end := DayBounds(day)
+	rows, err := db.query(`SELECT id, ts, host FROM snapshots WHERE ts >= ? AND ts < ? AND (? = '' OR host = ?) ORDER BY ts ASC, id ASC`, tsValue(start), tsValue(end), host, host)
 	if err != nil { return nil, err }
 	defer rows.Close()
 	var out []SnapshotMeta
 	for rows.Next() {
 		var id int64
-		var tsUnix int64
-		if err := rows.Scan(&id, &tsUnix); err != nil { return nil, err }
-		out = append(out, SnapshotMeta{ID: id, TS: time.Unix(tsUnix, 0).In(day.Location())})
+		var ts int64
+		var h string
+		if err := rows.Scan(&id, &ts, &h); err != nil { return nil, err }
+		out = append(out, SnapshotMeta{ID: id, TS: fromTS(ts).In(day.Location()), Host: h})
 	}
 	return out, rows.Err()
 }
 
 // LoadSnapshot loads a full snapshot by id.
-func (db *DB) LoadSnapshot(id int64) (types.Snapshot, error) {
-	var tsUnix int64
-	err := db.QueryRow(`SELECT ts FROM snapshots WHERE id=?`, id).Scan(&tsUnix)
+func (db *sqlStore) LoadSnapshot(id int64) (types.Snapshot, error) {
+	var ts, dur int64
+	var labels string
+	s := types.Snapshot{ID: id}
+	err := db.queryRow(`SELECT ts, host, machine_id, labels, duration_ns FROM snapshots WHERE id=?`, id).Scan(&ts, &s.Host.Hostname, &s.Host.MachineID, &labels, &dur)
 	if err != nil { return types.Snapshot{}, err }
-	s := types.Snapshot{ID: id, TS: time.Unix(tsUnix, 0)}
+	s.TS = fromTS(ts)
+	s.Duration = time.Duration(dur)
+	s.Host.Labels = decodeLabels(labels)
 	// GPUs
-	rows, err := db.Query(`SELECT gpu_index,name,uuid,util_gpu,util_mem,mem_used_mb,mem_total_mb,temp_c,power_w,power_limit_w FROM gpu_stats WHERE snapshot_id=?`, id)
+	rows, err := db.query(`SELECT gpu_index,name,uuid,util_gpu,util_mem,mem_used_mb,mem_total_mb,temp_c,power_w,power_limit_w FROM gpu_stats WHERE snapshot_id=?`, id)
 	if err != nil { return types.Snapshot{}, err }
 	for rows.Next() {
 		var g types.GPU
@@ -128,7 +317,7 @@ func (db *DB) LoadSnapshot(id int64) (types.Snapshot, error) {
 	}
 	rows.Close()
 	// Procs
-	rows, err = db.Query(`SELECT gpu_uuid,pid,process_name,used_mem_mb,user FROM proc_stats WHERE snapshot_id=?`, id)
+	rows, err = db.query(`SELECT gpu_uuid,pid,process_name,used_mem_mb,"user" FROM proc_stats WHERE snapshot_id=?`, id)
 	if err != nil { return types.Snapshot{}, err }
 	for rows.Next() {
 		var p types.GPUProcess
@@ -142,10 +331,84 @@ func (db *DB) LoadSnapshot(id int64) (types.Snapshot, error) {
 var ErrNoSnapshots = errors.New("no snapshots")
 
 // LoadLatest returns the latest snapshot or ErrNoSnapshots.
-func (db *DB) LoadLatest() (types.Snapshot, error) {
+func (db *sqlStore) LoadLatest(host string) (types.Snapshot, error) {
 	var id, ts int64
-	err := db.QueryRow(`SELECT id, ts FROM snapshots ORDER BY ts DESC, id DESC LIMIT 1`).Scan(&id, &ts)
+	err := db.queryRow(`SELECT id, ts FROM snapshots WHERE (? = '' OR host = ?) ORDER BY ts DESC, id DESC LIMIT 1`, host, host).Scan(&id, &ts)
+	if err == sql.ErrNoRows { return types.Snapshot{}, ErrNoSnapshots }
+	if err != nil { return types.Snapshot{}, err }
+	return db.LoadSnapshot(id)
+}
+
+// LoadAt loads the last snapshot taken at or before t.
+func (db *sqlStore) LoadAt(t time.Time, host string) (types.Snapshot, error) {
+	var id int64
+	err := db.queryRow(`SELECT id FROM snapshots WHERE ts <= ? AND (? = '' OR host = ?) ORDER BY ts DESC, id DESC LIMIT 1`, tsValue(t), host, host).Scan(&id)
 	if err == sql.ErrNoRows { return types.Snapshot{}, ErrNoSnapshots }
 	if err != nil { return types.Snapshot{}, err }
 	return db.LoadSnapshot(id)
 }
+
+// ListSnapshotsRange returns snapshot metas with from <= ts < to, oldest first.
+func (db *sqlStore) ListSnapshotsRange(from, to time.Time, host string) ([]SnapshotMeta, error) {
+	rows, err := db.query(`SELECT id, ts, host FROM snapshots WHERE ts >= ? AND ts < ? AND (? = '' OR host = ?) ORDER BY ts ASC, id ASC`,
+		tsValue(from), tsValue(to), host, host)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []SnapshotMeta
+	for rows.Next() {
+		var m SnapshotMeta
+		var ts int64
+		if err := rows.Scan(&m.ID, &ts, &m.Host); err != nil {
+			return nil, err
+		}
+		m.TS = fromTS(ts)
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// WalkRange loads snapshots with from <= ts < to one at a time, oldest first,
+// and calls fn for each. Iteration stops at the first error returned by fn.
+func (db *sqlStore) WalkRange(from, to time.Time, host string, fn func(types.Snapshot) error) error {
+	metas, err := db.ListSnapshotsRange(from, to, host)
+	if err != nil {
+		return err
+	}
+	for _, m := range metas {
+		s, err := db.LoadSnapshot(m.ID)
+		if err != nil {
+			return err
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListHosts returns every host found in the history with the labels of its
+// latest snapshot, sorted by hostname.
+func (db *sqlStore) ListHosts() ([]types.Host, error) {
+	// The newest snapshot by ts, not by id: imports and merges insert old
+	// snapshots after new ones.
+	rows, err := db.query(`SELECT host, machine_id, labels FROM snapshots WHERE id IN (
+		SELECT (SELECT id FROM snapshots s WHERE s.host = h.host AND s.machine_id = h.machine_id ORDER BY ts DESC, id DESC LIMIT 1)
+		FROM (SELECT DISTINCT host, machine_id FROM snapshots) h) ORDER BY host, machine_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []types.Host
+	for rows.Next() {
+		var h types.Host
+		var labels string
+		if err := rows.Scan(&h.Hostname, &h.MachineID, &labels); err != nil {
+			return nil, err
+		}
+		h.Labels = decodeLabels(labels)
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// TestSQLite runs the conformance suite against the reference store.
+func TestSQLite(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	storeSuite(t, db)
+}
+
+// TestPostgres runs the suite in a fresh schema of the database named by
+// $GPUWATCH_PG_DSN, e.g. postgres://gpuwatch@localhost/gpuwatch_test?sslmode=disable.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("GPUWATCH_PG_DSN")
+	if dsn == "" {
+		t.Skip("GPUWATCH_PG_DSN not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	schema := fmt.Sprintf("gpuwatch_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	db, err := OpenPostgres(dsn + sep + "search_path=" + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	storeSuite(t, db)
+}
+
+func testSnapshot(host, machineID string, ts time.Time, labels map[string]string) types.Snapshot {
+	uuid := "GPU-" + host
+	return types.Snapshot{
+		TS:       ts,
+		Duration: 42 * time.Millisecond,
+		Host:     types.Host{Hostname: host, MachineID: machineID, Labels: labels},
+		GPUs: []types.GPU{
+			{Index: 0, Name: "NVIDIA A100", UUID: uuid, UtilGPU: 45, UtilMem: 20, MemUsedMB: 3000, MemTotalMB: 40960, TempC: 60, PowerDrawW: 250.5, PowerLimitW: 400},
+		},
+		Procs: []types.GPUProcess{
+			{PID: 101, ProcessName: "python", UsedMemMB: 2000, GPUUUID: uuid, User: "alice"},
+			{PID: 202, ProcessName: "train", UsedMemMB: 1000, GPUUUID: uuid, User: "bob"},
+		},
+	}
+}
+
+// storeSuite checks every Store method against the behaviour of the SQLite
+// store. db must be empty.
+func storeSuite(t *testing.T, db Store) {
+	loc := time.FixedZone("UTC+2", 2*3600)
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, loc)
+	t0 := day.Add(10 * time.Hour).Add(123456789) // nanoseconds survive the round trip
+
+	if _, err := db.LoadLatest(""); !errors.Is(err, ErrNoSnapshots) {
+		t.Fatalf("LoadLatest on empty store: %v, want ErrNoSnapshots", err)
+	}
+	if hosts, err := db.ListHosts(); err != nil || len(hosts) != 0 {
+		t.Fatalf("ListHosts on empty store = %v, %v", hosts, err)
+	}
+
+	a1 := testSnapshot("alpha", "m-alpha", t0, map[string]string{"rack": "a1"})
+	b1 := testSnapshot("beta", "m-beta", t0.Add(time.Second), nil)
+	a2 := testSnapshot("alpha", "m-alpha", t0.Add(2*time.Second), map[string]string{"rack": "a2"})
+	a3 := testSnapshot("alpha", "m-alpha", day.Add(25*time.Hour), map[string]string{"rack": "a2"}) // next day
+	ids := make(map[string]int64)
+	for _, c := range []struct {
+		name string
+		snap types.Snapshot
+	}{{"a3", a3}, {"a1", a1}, {"b1", b1}, {"a2", a2}} { // not in time order
+		id, err := db.SaveSnapshot(c.snap)
+		if err != nil {
+			t.Fatalf("SaveSnapshot %s: %v", c.name, err)
+		}
+		ids[c.name] = id
+	}
+	if len(map[int64]bool{ids["a1"]: true, ids["b1"]: true, ids["a2"]: true, ids["a3"]: true}) != 4 {
+		t.Fatalf("SaveSnapshot IDs not unique: %v", ids)
+	}
+
+	t.Run("LoadSnapshot", func(t *testing.T) {
+		got, err := db.LoadSnapshot(ids["a1"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := a1
+		want.ID = ids["a1"]
+		if !got.TS.Equal(want.TS) {
+			t.Errorf("TS = %v, want %v", got.TS, want.TS)
+		}
+		got.TS, want.TS = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("LoadSnapshot =\n%+v\nwant\n%+v", got, want)
+		}
+	})
+
+	t.Run("ImportSnapshot", func(t *testing.T) {
+		id, inserted, err := db.ImportSnapshot(a1)
+		if err != nil || inserted || id != ids["a1"] {
+			t.Errorf("re-import of a1 = %d, %v, %v; want %d, false", id, inserted, err, ids["a1"])
+		}
+		other := a1
+		other.Host.MachineID = "m-alpha-2" // same hostname and time, different machine
+		id, inserted, err = db.ImportSnapshot(other)
+		if err != nil || !inserted || id == ids["a1"] {
+			t.Fatalf("import from another machine = %d, %v, %v; want a new row", id, inserted, err)
+		}
+		ids["a1'"] = id
+		if _, inserted, _ := db.ImportSnapshot(other); inserted {
+			t.Error("second import of the same snapshot inserted again")
+		}
+	})
+
+	metaIDs := func(ms []SnapshotMeta) []int64 {
+		var out []int64
+		for _, m := range ms {
+			out = append(out, m.ID)
+		}
+		return out
+	}
+
+	t.Run("ListSnapshotsByDate", func(t *testing.T) {
+		ms, err := db.ListSnapshotsByDate(day.Add(12*time.Hour), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := metaIDs(ms), []int64{ids["a1"], ids["a1'"], ids["b1"], ids["a2"]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("all hosts = %v, want %v", got, want)
+		}
+		if ms[0].TS.Location() != loc || !ms[0].TS.Equal(t0) || ms[0].Host != "alpha" {
+			t.Errorf("meta = %+v, want %v in %v on alpha", ms[0], t0, loc)
+		}
+		ms, err = db.ListSnapshotsByDate(day, "beta")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := metaIDs(ms), []int64{ids["b1"]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("beta = %v, want %v", got, want)
+		}
+		ms, err = db.ListSnapshotsByDate(day.AddDate(0, 0, 1), "alpha")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := metaIDs(ms), []int64{ids["a3"]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("alpha next day = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("ListSnapshotsRange", func(t *testing.T) {
+		ms, err := db.ListSnapshotsRange(t0, t0.Add(2*time.Second), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := metaIDs(ms), []int64{ids["a1"], ids["a1'"], ids["b1"]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("[t0, t0+2s) = %v, want %v (end exclusive)", got, want)
+		}
+		ms, err = db.ListSnapshotsRange(day, day.AddDate(0, 0, 2), "alpha")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := metaIDs(ms), []int64{ids["a1"], ids["a1'"], ids["a2"], ids["a3"]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("alpha = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("WalkRange", func(t *testing.T) {
+		var got []int64
+		err := db.WalkRange(day, day.AddDate(0, 0, 2), "alpha", func(s types.Snapshot) error {
+			if s.Host.Hostname != "alpha" || len(s.GPUs) != 1 || len(s.Procs) != 2 {
+				t.Errorf("walked incomplete snapshot %+v", s)
+			}
+			got = append(got, s.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int64{ids["a1"], ids["a1'"], ids["a2"], ids["a3"]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("walked %v, want %v", got, want)
+		}
+
+		stop := errors.New("stop")
+		n := 0
+		err = db.WalkRange(day, day.AddDate(0, 0, 2), "", func(types.Snapshot) error {
+			n++
+			return stop
+		})
+		if err != stop || n != 1 {
+			t.Errorf("WalkRange after fn error = %v after %d calls, want stop after 1", err, n)
+		}
+	})
+
+	t.Run("LoadAt", func(t *testing.T) {
+		mid := t0.Add(1500 * time.Millisecond)
+		if s, err := db.LoadAt(mid, ""); err != nil || s.ID != ids["b1"] {
+			t.Errorf("LoadAt(t0+1.5s) = %d, %v; want %d", s.ID, err, ids["b1"])
+		}
+		if s, err := db.LoadAt(mid, "alpha"); err != nil || s.ID != ids["a1'"] {
+			t.Errorf("LoadAt(t0+1.5s, alpha) = %d, %v; want %d", s.ID, err, ids["a1'"])
+		}
+		if s, err := db.LoadAt(t0.Add(time.Second), "beta"); err != nil || s.ID != ids["b1"] {
+			t.Errorf("LoadAt(exact time, beta) = %d, %v; want %d", s.ID, err, ids["b1"])
+		}
+		if _, err := db.LoadAt(t0.Add(-time.Nanosecond), ""); !errors.Is(err, ErrNoSnapshots) {
+			t.Errorf("LoadAt before the first snapshot: %v, want ErrNoSnapshots", err)
+		}
+	})
+
+	t.Run("LoadLatest", func(t *testing.T) {
+		if s, err := db.LoadLatest(""); err != nil || s.ID != ids["a3"] {
+			t.Errorf("LoadLatest() = %d, %v; want %d", s.ID, err, ids["a3"])
+		}
+		if s, err := db.LoadLatest("beta"); err != nil || s.ID != ids["b1"] {
+			t.Errorf("LoadLatest(beta) = %d, %v; want %d", s.ID, err, ids["b1"])
+		}
+		if _, err := db.LoadLatest("gamma"); !errors.Is(err, ErrNoSnapshots) {
+			t.Errorf("LoadLatest(unknown host): %v, want ErrNoSnapshots", err)
+		}
+	})
+
+	t.Run("ListHosts", func(t *testing.T) {
+		// Imported after every other snapshot but older than all of them:
+		// the labels still come from the newest snapshot by time.
+		old := testSnapshot("beta", "m-beta", t0.Add(-time.Hour), map[string]string{"rack": "old"})
+		if _, added, err := db.ImportSnapshot(old); err != nil || !added {
+			t.Fatalf("ImportSnapshot(older beta) = %v, %v", added, err)
+		}
+		hosts, err := db.ListHosts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []types.Host{
+			{Hostname: "alpha", MachineID: "m-alpha", Labels: map[string]string{"rack": "a2"}},
+			{Hostname: "alpha", MachineID: "m-alpha-2", Labels: map[string]string{"rack": "a1"}},
+			{Hostname: "beta", MachineID: "m-beta"},
+		}
+		if !reflect.DeepEqual(hosts, want) {
+			t.Errorf("ListHosts =\n%+v\nwant\n%+v", hosts, want)
+		}
+	})
+}
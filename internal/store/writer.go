@@ -0,0 +1,254 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+var ErrWriterClosed = errors.New("writer closed")
+
+// WriterOptions tune a Writer; zero values select the defaults.
+type WriterOptions struct {
+	QueueSize     int           // snapshots buffered before Enqueue blocks (default 256)
+	BatchSize     int           // snapshots per transaction (default 64)
+	FlushInterval time.Duration // longest time a snapshot waits in the queue (default 1s)
+}
+
+// WriterStats are the writer's backpressure and error metrics.
+type WriterStats struct {
+	Queued   int    // snapshots waiting to be written
+	Capacity int    // queue size
+	Written  uint64 // snapshots committed
+	Batches  uint64 // transactions committed
+	Blocked  uint64 // Enqueue calls that waited for room in a full queue
+	Dropped  uint64 // snapshots rejected by TryEnqueue or lost in a batch that failed twice
+	Errors   uint64 // failed batch writes, retries included
+	LastErr  error
+}
+
+type batchSaver interface {
+	SaveBatch([]types.Snapshot) ([]int64, error)
+}
+
+// Writer saves snapshots from a bounded queue on a background goroutine,
+// batching them into few transactions so slow disks don't stall sampling.
+type Writer struct {
+	db    Store
+	opts  WriterOptions
+	queue chan types.Snapshot
+	flush chan chan error
+	done  chan struct{}
+
+	// Enqueue holds enqueueMu for reading while it sends, and Close takes
+	// it for writing after closing closed, so no send can land after stop
+	// tells run to write what is left.
+	enqueueMu sync.RWMutex
+	closeOnce sync.Once
+	closed    chan struct{}
+	stop      chan struct{}
+
+	mu    sync.Mutex
+	stats WriterStats
+}
+
+// NewWriter starts a writer over db. Call Close to flush and stop it.
+func NewWriter(db Store, opts WriterOptions) *Writer {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 256
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 64
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	w := &Writer{
+		db:     db,
+		opts:   opts,
+		queue:  make(chan types.Snapshot, opts.QueueSize),
+		flush:  make(chan chan error),
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	w.stats.Capacity = opts.QueueSize
+	go w.run()
+	return w
+}
+
+// Enqueue queues s, waiting for room when the queue is full. It fails with
+// ErrWriterClosed once Close has been called.
+func (w *Writer) Enqueue(s types.Snapshot) error {
+	w.enqueueMu.RLock()
+	defer w.enqueueMu.RUnlock()
+	select {
+	case <-w.closed:
+		return ErrWriterClosed
+	default:
+	}
+	select {
+	case w.queue <- s:
+		return nil
+	default:
+	}
+	w.mu.Lock()
+	w.stats.Blocked++
+	w.mu.Unlock()
+	select {
+	case w.queue <- s:
+		return nil
+	case <-w.closed:
+		return ErrWriterClosed
+	}
+}
+
+// TryEnqueue queues s without waiting; it reports false and counts a drop
+// when the queue is full.
+func (w *Writer) TryEnqueue(s types.Snapshot) bool {
+	w.enqueueMu.RLock()
+	defer w.enqueueMu.RUnlock()
+	select {
+	case <-w.closed:
+		return false
+	default:
+	}
+	select {
+	case w.queue <- s:
+		return true
+	default:
+		w.mu.Lock()
+		w.stats.Dropped++
+		w.mu.Unlock()
+		return false
+	}
+}
+
+// Flush writes everything queued so far and returns the last batch error.
+func (w *Writer) Flush() error {
+	reply := make(chan error, 1)
+	select {
+	case w.flush <- reply:
+		return <-reply
+	case <-w.done:
+		return ErrWriterClosed
+	}
+}
+
+// Close stops accepting snapshots, writes the remaining queue and waits for
+// the background goroutine to exit.
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closed)
+		w.enqueueMu.Lock() // wait for Enqueue calls in flight
+		w.enqueueMu.Unlock()
+		close(w.stop)
+	})
+	<-w.done
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats.LastErr
+}
+
+// Stats returns a copy of the current metrics.
+func (w *Writer) Stats() WriterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := w.stats
+	st.Queued = len(w.queue)
+	return st
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]types.Snapshot, 0, w.opts.BatchSize)
+	for {
+		select {
+		case s := <-w.queue:
+			batch = append(batch, s)
+			if len(batch) >= w.opts.BatchSize {
+				w.write(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.write(batch)
+			batch = batch[:0]
+		case reply := <-w.flush:
+			batch = w.drain(batch)
+			reply <- w.write(batch)
+			batch = batch[:0]
+		case <-w.stop:
+			w.write(w.drain(batch))
+			return
+		}
+	}
+}
+
+// drain moves every queued snapshot into batch, writing full batches.
+func (w *Writer) drain(batch []types.Snapshot) []types.Snapshot {
+	for {
+		select {
+		case s := <-w.queue:
+			batch = append(batch, s)
+			if len(batch) >= w.opts.BatchSize {
+				w.write(batch)
+				batch = batch[:0]
+			}
+		default:
+			return batch
+		}
+	}
+}
+
+// retryDelay is the pause before a failed batch is written again.
+const retryDelay = 100 * time.Millisecond
+
+// write saves batch, retrying what was not saved once before dropping it.
+func (w *Writer) write(batch []types.Snapshot) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	n, err := w.save(batch)
+	if err != nil {
+		w.mu.Lock()
+		w.stats.Errors++
+		w.stats.LastErr = err
+		w.mu.Unlock()
+		time.Sleep(retryDelay)
+		var m int
+		m, err = w.save(batch[n:])
+		n += m
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats.Written += uint64(n)
+	if err != nil {
+		w.stats.Errors++
+		w.stats.Dropped += uint64(len(batch) - n)
+		w.stats.LastErr = err
+		return err
+	}
+	w.stats.Batches++
+	return nil
+}
+
+// save writes batch and returns how many snapshots were committed: all or
+// none with SaveBatch, else those saved before the first error.
+func (w *Writer) save(batch []types.Snapshot) (int, error) {
+	if b, ok := w.db.(batchSaver); ok {
+		if _, err := b.SaveBatch(batch); err != nil {
+			return 0, err
+		}
+		return len(batch), nil
+	}
+	for i, s := range batch {
+		if _, err := w.db.SaveSnapshot(s); err != nil {
+			return i, err
+		}
+	}
+	return len(batch), nil
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+// TestWriterCloseWhileEnqueueing checks that every snapshot Enqueue accepted
+// is saved, even when Close races with the senders.
+func TestWriterCloseWhileEnqueueing(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	w := NewWriter(db, WriterOptions{QueueSize: 4, BatchSize: 3})
+
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				s := testSnapshot("alpha", "m-alpha", time.Unix(int64(g*100000+i), 0), nil)
+				if err := w.Enqueue(s); err != nil {
+					if err != ErrWriterClosed {
+						t.Error(err)
+					}
+					return
+				}
+				accepted.Add(1)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if err := w.Enqueue(types.Snapshot{}); err != ErrWriterClosed {
+		t.Errorf("Enqueue after Close = %v, want ErrWriterClosed", err)
+	}
+	if w.TryEnqueue(types.Snapshot{}) {
+		t.Error("TryEnqueue after Close succeeded")
+	}
+	ms, err := db.ListSnapshotsRange(time.Unix(0, 0), maxTime, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := w.Stats()
+	if int64(len(ms)) != accepted.Load() || st.Written != uint64(accepted.Load()) || st.Dropped != 0 {
+		t.Errorf("accepted %d, stored %d, stats %+v", accepted.Load(), len(ms), st)
+	}
+}
+
+// flakyStore fails its first `failures` batch writes.
+type flakyStore struct {
+	Store
+	mu       sync.Mutex
+	failures int
+	saved    int
+}
+
+func (f *flakyStore) SaveBatch(snaps []types.Snapshot) ([]int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return nil, errors.New("database is locked")
+	}
+	f.saved += len(snaps)
+	return make([]int64, len(snaps)), nil
+}
+
+func TestWriterRetriesFailedBatch(t *testing.T) {
+	for _, tc := range []struct {
+		failures       int
+		saved, dropped int
+	}{
+		{failures: 1, saved: 2},   // the retry succeeds
+		{failures: 2, dropped: 2}, // the retry fails too: the batch is lost
+	} {
+		db := &flakyStore{failures: tc.failures}
+		w := NewWriter(db, WriterOptions{BatchSize: 2})
+		for i := 0; i < 2; i++ {
+			if err := w.Enqueue(types.Snapshot{TS: time.Unix(int64(i), 0)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Close()
+		st := w.Stats()
+		if db.saved != tc.saved || st.Written != uint64(tc.saved) || st.Dropped != uint64(tc.dropped) || st.Errors != uint64(tc.failures) {
+			t.Errorf("%d failures: saved %d, stats %+v; want %d saved, %d dropped", tc.failures, db.saved, st, tc.saved, tc.dropped)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"gpuwatch/internal/diff"
+	"gpuwatch/internal/types"
+)
+
+// Heartbeat is how often an idle stream is pinged so proxies keep it open
+// and dead clients are noticed.
+const Heartbeat = 15 * time.Second
+
+// writeTimeout bounds a single write to a client; a stalled connection is
+// closed instead of holding its handler forever.
+const writeTimeout = 10 * time.Second
+
+// Message is one event on a stream. The first event of each host is always
+// a full snapshot; with ?diff=1 the following ones are changes since that
+// host's previous event.
+type Message struct {
+	Type     string          // "snapshot" or "diff"
+	Snapshot *types.Snapshot `json:",omitempty"`
+	Diff     *diff.Diff      `json:",omitempty"`
+}
+
+// request holds the query parameters shared by both transports:
+// ?host=, ?gpu=0,1, ?user= and ?diff=1.
+type request struct {
+	filter Filter
+	diff   bool
+}
+
+func parseRequest(r *http.Request) (request, error) {
+	q := r.URL.Query()
+	req := request{filter: Filter{Host: q.Get("host"), User: q.Get("user")}}
+	if v := q.Get("gpu"); v != "" {
+		for _, f := range strings.Split(v, ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil || i < 0 {
+				return req, fmt.Errorf("invalid gpu %q", f)
+			}
+			req.filter.GPUs = append(req.filter.GPUs, i)
+		}
+	}
+	switch v := q.Get("diff"); v {
+	case "", "0", "false":
+	case "1", "true":
+		req.diff = true
+	default:
+		return req, fmt.Errorf("invalid diff %q", v)
+	}
+	return req, nil
+}
+
+// messages turns a subscriber's snapshots into events. Diffs are taken
+// against the previous snapshot of the same host, as a stream without a
+// host filter interleaves the hosts of an aggregator or -hosts collector.
+type messages struct {
+	diff bool
+	prev map[string]*types.Snapshot // by host key
+}
+
+func (m *messages) next(s types.Snapshot) Message {
+	if !m.diff {
+		return Message{Type: "snapshot", Snapshot: &s}
+	}
+	if m.prev == nil {
+		m.prev = make(map[string]*types.Snapshot)
+	}
+	hk := s.Host.Key()
+	prev, ok := m.prev[hk]
+	m.prev[hk] = &s
+	if !ok {
+		return Message{Type: "snapshot", Snapshot: &s}
+	}
+	d, err := diff.Compare(*prev, s)
+	if err != nil {
+		return Message{Type: "snapshot", Snapshot: &s}
+	}
+	return Message{Type: "diff", Diff: &d}
+}
+
+// ServeSSE streams snapshots as Server-Sent Events: "snapshot" and "diff"
+// events carry a Message as JSON, a "dropped" event ends the stream of a
+// client that fell behind, and comments are sent as heartbeats.
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	req, err := parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	sub := h.Subscribe(req.filter)
+	defer h.Unsubscribe(sub)
+	msgs := messages{diff: req.diff}
+	ping := time.NewTicker(Heartbeat)
+	defer ping.Stop()
+	send := func(format string, args ...any) bool {
+		_ = rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !send(": gpuwatch stream\nretry: 2000\n\n") {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			if !send(": ping\n\n") {
+				return
+			}
+		case s, ok := <-sub.C:
+			if !ok {
+				send("event: dropped\ndata: {\"error\":\"slow consumer\"}\n\n")
+				return
+			}
+			m := msgs.next(s)
+			data, err := json.Marshal(m)
+			if err != nil || !send("event: %s\ndata: %s\n\n", m.Type, data) {
+				return
+			}
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 16 * 1024,
+}
+
+// ServeWS streams the same events as ServeSSE as WebSocket text messages,
+// one Message per frame. Heartbeats are ping frames; a client that does not
+// answer within two heartbeats, or falls behind, is disconnected.
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	req, err := parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has replied
+	}
+	defer conn.Close()
+
+	// Read only to process control frames and notice the client leaving.
+	closed := make(chan struct{})
+	conn.SetReadLimit(4096)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * Heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * Heartbeat))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	sub := h.Subscribe(req.filter)
+	defer h.Unsubscribe(sub)
+	msgs := messages{diff: req.diff}
+	ping := time.NewTicker(Heartbeat)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closed:
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return
+			}
+		case s, ok := <-sub.C:
+			if !ok {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "slow consumer"), time.Now().Add(writeTimeout))
+				return
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteJSON(msgs.next(s)); err != nil {
+				return
+			}
+		}
+	}
+}
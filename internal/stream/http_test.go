@@ -0,0 +1,43 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"gpuwatch/internal/types"
+)
+
+func TestMessagesDiffPerHost(t *testing.T) {
+	snap := func(host string, sec int64, mem float64) types.Snapshot {
+		return types.Snapshot{
+			TS:    time.Unix(sec, 0),
+			Host:  types.Host{Hostname: host, MachineID: "m-" + host},
+			GPUs:  []types.GPU{{UUID: "GPU-" + host, MemUsedMB: mem}},
+			Procs: []types.GPUProcess{{PID: 1, GPUUUID: "GPU-" + host, UsedMemMB: mem, User: "alice"}},
+		}
+	}
+	m := messages{diff: true}
+	for i, c := range []struct {
+		snap types.Snapshot
+		typ  string
+	}{
+		{snap("a", 1, 100), "snapshot"},
+		{snap("b", 2, 900), "snapshot"}, // first of b: not a diff against a
+		{snap("a", 3, 150), "diff"},
+		{snap("b", 4, 950), "diff"},
+	} {
+		msg := m.next(c.snap)
+		if msg.Type != c.typ {
+			t.Fatalf("message %d: %s, want %s", i, msg.Type, c.typ)
+		}
+		if msg.Type != "diff" {
+			continue
+		}
+		if msg.Diff.Host != c.snap.Host.Hostname || msg.Diff.Elapsed != 2*time.Second {
+			t.Errorf("message %d: diff of %s over %s, want %s over 2s", i, msg.Diff.Host, msg.Diff.Elapsed, c.snap.Host.Hostname)
+		}
+		if len(msg.Diff.Changed) != 1 || msg.Diff.Changed[0].MemMB.Change != 50 {
+			t.Errorf("message %d: changes %+v, want one process +50 MB", i, msg.Diff.Changed)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package stream fans each new sample out to any number of live viewers over
+// Server-Sent Events and WebSocket.
+package stream
+
+import (
+	"strings"
+	"sync"
+
+	"gpuwatch/internal/types"
+)
+
+// DefaultBuffer is how many snapshots a subscriber may fall behind before it
+// is dropped.
+const DefaultBuffer = 16
+
+// Filter narrows the snapshots a subscriber receives, like the TUI filters.
+type Filter struct {
+	Host string // hostname; "" = every host
+	GPUs []int  // GPU indexes; nil = every GPU
+	User string // process owner, case-insensitive; "" = every user
+}
+
+// Apply returns s restricted to the filter; ok is false when s is from
+// another host.
+func (f Filter) Apply(s types.Snapshot) (out types.Snapshot, ok bool) {
+	if f.Host != "" && s.Host.Hostname != f.Host {
+		return s, false
+	}
+	if f.GPUs != nil {
+		keep := make(map[string]bool)
+		var gpus []types.GPU
+		for _, g := range s.GPUs {
+			for _, i := range f.GPUs {
+				if g.Index == i {
+					gpus = append(gpus, g)
+					keep[g.UUID] = true
+					break
+				}
+			}
+		}
+		var procs []types.GPUProcess
+		for _, p := range s.Procs {
+			if keep[p.GPUUUID] {
+				procs = append(procs, p)
+			}
+		}
+		s.GPUs, s.Procs = gpus, procs
+	}
+	if f.User != "" {
+		var procs []types.GPUProcess
+		for _, p := range s.Procs {
+			if strings.EqualFold(p.User, f.User) {
+				procs = append(procs, p)
+			}
+		}
+		s.Procs = procs
+	}
+	return s, true
+}
+
+// Sub is one subscriber. C is closed when the subscriber is dropped for
+// falling behind or unsubscribed.
+type Sub struct {
+	C      <-chan types.Snapshot
+	ch     chan types.Snapshot
+	filter Filter
+}
+
+// Stats counts hub activity.
+type Stats struct {
+	Clients   int
+	Published uint64
+	Dropped   uint64 // subscribers disconnected for being too slow
+}
+
+// Hub publishes snapshots to subscribers without ever blocking the sampler:
+// a subscriber whose buffer is full is dropped.
+type Hub struct {
+	buffer int
+
+	mu    sync.Mutex
+	subs  map[*Sub]struct{}
+	last  *types.Snapshot
+	stats Stats
+}
+
+// NewHub returns a hub with buffer snapshots per subscriber (DefaultBuffer if
+// buffer <= 0).
+func NewHub(buffer int) *Hub {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &Hub{buffer: buffer, subs: make(map[*Sub]struct{})}
+}
+
+// Subscribe registers a subscriber. The latest snapshot, if any, is queued
+// right away so new viewers do not wait for the next sample.
+func (h *Hub) Subscribe(f Filter) *Sub {
+	ch := make(chan types.Snapshot, h.buffer)
+	s := &Sub{C: ch, ch: ch, filter: f}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.last != nil {
+		if snap, ok := f.Apply(*h.last); ok {
+			ch <- snap
+		}
+	}
+	h.subs[s] = struct{}{}
+	h.stats.Clients = len(h.subs)
+	return s
+}
+
+// Unsubscribe removes s and closes its channel; it is a no-op for a
+// subscriber that was already dropped.
+func (h *Hub) Unsubscribe(s *Sub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.ch)
+		h.stats.Clients = len(h.subs)
+	}
+}
+
+// Publish delivers snap to every matching subscriber.
+func (h *Hub) Publish(snap types.Snapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = &snap
+	h.stats.Published++
+	for s := range h.subs {
+		out, ok := s.filter.Apply(snap)
+		if !ok {
+			continue
+		}
+		select {
+		case s.ch <- out:
+		default:
+			delete(h.subs, s)
+			close(s.ch)
+			h.stats.Dropped++
+		}
+	}
+	h.stats.Clients = len(h.subs)
+}
+
+// Stats returns a copy of the hub counters.
+func (h *Hub) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
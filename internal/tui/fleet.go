@@ -0,0 +1,217 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gpuwatch/internal/fleet"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// fleetMsg carries a fleet summary; gen tells reloads of an earlier visit of
+// the fleet view apart, so only one reload loop runs.
+type fleetMsg struct {
+	sum fleet.Summary
+	gen int
+	err error
+}
+
+// loadFleetCmd summarizes the latest stored snapshot of every host. Hosts
+// are stale after three missed samples (at least fleet.DefaultStaleAfter).
+func (m model) loadFleetCmd() tea.Cmd {
+	gen := m.fleetGen
+	cfg := fleet.Config{MaxTemp: m.config.MaxTemp, MaxMem: m.config.MaxMem, StaleAfter: fleet.DefaultStaleAfter}
+	if d := 3 * m.config.SampleInterval; d > cfg.StaleAfter {
+		cfg.StaleAfter = d
+	}
+	return func() tea.Msg {
+		snaps, err := fleet.Load(m.db)
+		if err != nil {
+			return fleetMsg{gen: gen, err: err}
+		}
+		return fleetMsg{sum: fleet.Summarize(snaps, time.Now(), cfg), gen: gen}
+	}
+}
+
+// reloadFleet starts a new reload loop, ending the previous one.
+func (m model) reloadFleet() (model, tea.Cmd) {
+	m.fleetGen++
+	return m, m.loadFleetCmd()
+}
+
+// fleetTick schedules the next reload of the current loop.
+func (m model) fleetTick() tea.Cmd {
+	return tea.Tick(m.config.SampleInterval, func(time.Time) tea.Msg { return m.loadFleetCmd()() })
+}
+
+// enterFleet switches to the fleet overview, dropping a drill-down host
+// filter and going back to live mode.
+func (m model) enterFleet() (model, tea.Cmd) {
+	m.fleetView = true
+	m.config.Host = m.homeHost
+	m.compareBase = nil
+	m, cmd := m.reloadFleet()
+	if !m.live {
+		m.live = true
+		return m, tea.Batch(cmd, m.refreshOnce())
+	}
+	return m, cmd
+}
+
+// drillDown opens the detail view of the selected host: following it with
+// -readonly or when it is the sampled host, else its history of today.
+func (m model) drillDown() (model, tea.Cmd) {
+	if m.fleet == nil || m.fleetCursor >= len(m.fleet.Hosts) {
+		return m, nil
+	}
+	return m.showHost(m.fleet.Hosts[m.fleetCursor].Host.Hostname)
+}
+
+// showHost leaves the fleet overview for the detail view of host.
+func (m model) showHost(host string) (model, tea.Cmd) {
+	m.fleetView = false
+	m.config.Host = host
+	m.lastHost = host
+	m.filterUser, m.filterGPU = "", -1
+	if m.config.ReadOnly || (m.live && host == m.curr.Host.Hostname) {
+		m.live = true
+		m.curr.ID = 0
+		return m, m.refreshOnce()
+	}
+	m.live = false
+	m.jumpLast = true
+	m.historyDate = time.Now().In(m.config.Location)
+	return m, m.loadMetasCmd(m.historyDate)
+}
+
+// leaveFleet goes back to the single-host view. Following a store without a
+// -host filter, that is the host last drilled down into, or else the
+// selected one: the newest snapshot of any host would jump between machines.
+func (m model) leaveFleet() (model, tea.Cmd) {
+	if !m.config.ReadOnly || m.homeHost != "" {
+		m.fleetView = false
+		return m, nil
+	}
+	if m.lastHost != "" {
+		return m.showHost(m.lastHost)
+	}
+	return m.drillDown()
+}
+
+func (m model) updateFleet(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	n := 0
+	if m.fleet != nil {
+		n = len(m.fleet.Hosts)
+	}
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "?":
+		m.showHelp = !m.showHelp
+	case "up", "k":
+		if m.fleetCursor > 0 {
+			m.fleetCursor--
+		}
+	case "down", "j":
+		if m.fleetCursor < n-1 {
+			m.fleetCursor++
+		}
+	case "enter":
+		return m.drillDown()
+	case "r":
+		return m.reloadFleet()
+	case "F", "esc":
+		return m.leaveFleet()
+	}
+	return m, nil
+}
+
+func (m model) viewFleet() string {
+	status := "FLEET"
+	if m.fleet != nil {
+		t := m.fleet.Totals()
+		status = fmt.Sprintf("FLEET %d hosts, %d/%d GPUs free | updated %s", len(m.fleet.Hosts), t.FreeGPUs, t.GPUs,
+			m.fleetAt.In(m.config.Location).Format("15:04:05"))
+	}
+	header := headStyle.Render("gpuwatch — fleet") + "  " + subtle.Render(status)
+	if m.err != nil {
+		header += "  " + errStyle.Render(m.err.Error())
+	}
+	if p := m.config.Hosts; p != nil {
+		if down := p.Down(); len(down) > 0 {
+			header += "  " + errStyle.Render(fmt.Sprintf("%d/%d hosts down: %v", len(down), p.Hosts(), down[0]))
+		}
+	}
+	return header + "\n\n" + m.renderFleet() + "\n\n" + m.renderFleetHelp()
+}
+
+func (m model) renderFleet() string {
+	if m.fleet == nil {
+		return box.Width(m.width - 4).Render(subtle.Render("loading fleet…"))
+	}
+	if len(m.fleet.Hosts) == 0 {
+		return box.Width(m.width - 4).Render(subtle.Render("no hosts in the history yet"))
+	}
+	var b strings.Builder
+	b.WriteString(label.Render(fmt.Sprintf("  %-20s %5s %5s  %-23s %15s %7s %6s %6s %8s  %s",
+		"HOST", "GPUS", "FREE", "UTIL", "MEM (GB)", "POWER", "PROCS", "USERS", "AGE", "ALERTS")) + "\n")
+	row := func(h fleet.Host, age string) string {
+		return fmt.Sprintf("%-20s %5d %5d  %s %3.0f%% %7.0f/%-7.0f %6.0fW %6d %6d %8s",
+			trim(h.Host.Hostname, 20), h.GPUs, h.FreeGPUs, drawBar(h.UtilGPU, 100, 18), h.UtilGPU,
+			h.MemUsedMB/1024, h.MemTotalMB/1024, h.PowerW, h.Procs, h.Users, age)
+	}
+	for i, h := range m.fleet.Hosts {
+		cursor := "  "
+		if i == m.fleetCursor {
+			cursor = "► "
+		}
+		line := cursor + row(h, time.Since(h.TS).Round(time.Second).String())
+		switch {
+		case h.Stale:
+			line = subtle.Render(line) + "  " + errStyle.Render(strings.Join(h.Alerts, ", "))
+		case len(h.Alerts) > 0:
+			line += "  " + errStyle.Render(strings.Join(h.Alerts, ", "))
+		}
+		b.WriteString(line + "\n")
+	}
+	t := m.fleet.Totals()
+	b.WriteString(label.Render("  "+row(t, "")) + "\n")
+	hosts := box.Width(m.width - 4).Render(strings.TrimRight(b.String(), "\n"))
+
+	b.Reset()
+	b.WriteString(label.Render("Fleet per-user GPU memory (MB)") + "\n")
+	top := 1.0
+	for _, u := range m.fleet.Users {
+		if u.MemUsedMB > top {
+			top = u.MemUsedMB
+		}
+	}
+	if len(m.fleet.Users) == 0 {
+		b.WriteString(subtle.Render("no running GPU processes"))
+	}
+	for _, u := range m.fleet.Users {
+		b.WriteString(fmt.Sprintf("%-12s %s %8.0f  %d procs on %d GPUs  %s\n", trim(u.User, 12), drawBar(u.MemUsedMB, top, 30),
+			u.MemUsedMB, u.Procs, u.GPUs, subtle.Render(strings.Join(u.Hosts, ", "))))
+	}
+	users := box.Width(m.width - 4).Render(strings.TrimRight(b.String(), "\n"))
+	return hosts + "\n" + users
+}
+
+func (m model) renderFleetHelp() string {
+	if !m.showHelp {
+		return subtle.Render("↑/↓: select host | enter: details | r: refresh | F/esc: back | ?: help | q: quit")
+	}
+	return box.Width(m.width - 4).Render(strings.Join([]string{
+		"Fleet overview:",
+		"  ↑/↓ (k/j) — Select a host",
+		"  enter — Open the host's detail view (F there returns here)",
+		"  r — Reload the latest snapshot of every host",
+		"  F/esc — Back to the single-host view (following a store: the last host opened, or the selected one)",
+		"",
+		"Hosts silent for three sampling intervals (at least 2 minutes) are shown as stale.",
+		"",
+		"  q — Quit",
+	}, "\n"))
+}
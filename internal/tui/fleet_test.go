@@ -0,0 +1,71 @@
+package tui
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func key(m model, k string) (model, tea.Cmd) {
+	msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+	if k == "esc" {
+		msg = tea.KeyMsg{Type: tea.KeyEsc}
+	}
+	next, cmd := m.Update(msg)
+	return next.(model), cmd
+}
+
+// TestLeaveFleetKeepsHost checks that following a store of several hosts,
+// leaving the fleet overview shows one host instead of the newest snapshot
+// of any of them.
+func TestLeaveFleetKeepsHost(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	now := time.Now()
+	for i, h := range []string{"alpha", "beta"} {
+		s := types.Snapshot{TS: now.Add(time.Duration(i) * time.Second), Host: types.Host{Hostname: h}}
+		if _, err := db.SaveSnapshot(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewWithConfig(db, Config{SampleInterval: time.Second, ReadOnly: true, Fleet: true})
+	if m, _ = key(m, "esc"); !m.fleetView {
+		t.Fatal("left the fleet overview before it loaded")
+	}
+	next, _ := m.Update(m.loadFleetCmd()())
+	m = next.(model)
+
+	// No host opened yet: leaving opens the selected one.
+	m, cmd := key(m, "esc")
+	if m.fleetView || m.config.Host != "alpha" {
+		t.Fatalf("after esc: fleet %v, host %q; want alpha", m.fleetView, m.config.Host)
+	}
+	if msg, ok := cmd().(refreshMsg); !ok || msg.snap.Host.Hostname != "alpha" {
+		t.Errorf("after esc: loaded %+v, want alpha's latest snapshot", msg)
+	}
+
+	m, _ = key(m, "F")
+	m, _ = key(m, "j") // select beta, but leave without opening it
+	if m.config.Host != "" {
+		t.Errorf("fleet overview filters on %q", m.config.Host)
+	}
+	if m, _ = key(m, "F"); m.fleetView || m.config.Host != "alpha" {
+		t.Errorf("after F: fleet %v, host %q; want alpha", m.fleetView, m.config.Host)
+	}
+
+	m, _ = key(m, "F")
+	m, _ = key(m, "enter") // beta
+	m, _ = key(m, "F")
+	if m, _ = key(m, "esc"); m.config.Host != "beta" {
+		t.Errorf("back from the fleet on %q, want beta", m.config.Host)
+	}
+}
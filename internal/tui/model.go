@@ -5,7 +5,11 @@ import (
 	"strings"
 	"time"
 
+	"gpuwatch/internal/detect"
+	"gpuwatch/internal/energy"
+	"gpuwatch/internal/fleet"
 	"gpuwatch/internal/sampler"
+	"gpuwatch/internal/sink"
 	"gpuwatch/internal/store"
 	"gpuwatch/internal/types"
 
@@ -16,10 +20,21 @@ type Config struct {
 	SampleInterval time.Duration
 	MaxTemp        float64
 	MaxMem         float64
+	Host           string           // history host filter ("" = all hosts)
+	Location       *time.Location   // zone for displayed times and history days (nil = local)
+	Carbon         energy.Intensity // optional, for the history energy summary
+	MaxGap         time.Duration    // longest time one sample counts for in the energy summary
+	Idle           detect.IdleConfig
+	Leak           detect.LeakConfig
+	Writer         *store.Writer       // optional; auto-recorded samples are queued here instead of saved inline
+	ReadOnly       bool                // follow snapshots recorded by another process instead of sampling
+	Fleet          bool                // start in the fleet overview
+	Hosts          *sampler.HostPoller // -hosts collector recording in the background; set ReadOnly to follow it
+	Sinks          []*sink.Batcher     // every live sample is also written here, whether or not it is saved
 }
 
 type model struct {
-	db         *store.DB
+	db         store.Store
 	config     Config
 	live       bool
 	autoRecord bool
@@ -34,60 +49,140 @@ type model struct {
 	historyDate time.Time
 	metas       []store.SnapshotMeta
 	index       int // index into metas for current snapshot
+	dayEnergy   *energy.Report
+	compareBase *types.Snapshot // compare mode: baseline diffed against curr
 
 	showHelp bool
 
+	// fleet overview
+	fleetView   bool
+	fleet       *fleet.Summary
+	fleetAt     time.Time
+	fleetGen    int
+	fleetCursor int
+	homeHost    string // Config.Host before drilling down into a host
+	lastHost    string // host last drilled down into
+	jumpLast    bool   // open the last snapshot once the day's metas load
+
+	// idle allocations in live mode
+	idle       *detect.IdleDetector
+	idleAllocs []detect.IdleAlloc
+	leaks      *detect.LeakDetector
+	growth     map[procRef]detect.Growth
+
 	// filters
 	filterUser string
 	filterGPU  int // -1 means all GPUs
 	sortByMem  bool
 }
 
+// procRef identifies a process on a GPU.
+type procRef struct {
+	uuid string
+	pid  int
+}
+
 type (
 	refreshMsg struct{ snap types.Snapshot }
+	tailMsg    struct{} // no new snapshot in the store yet
 	savedMsg   struct{ id int64 }
 	metasMsg   struct{ metas []store.SnapshotMeta }
+	energyMsg  struct{ rep energy.Report }
 	errorMsg   struct{ err error }
 )
 
-func New(db *store.DB) model {
+func New(db store.Store) model {
 	return NewWithConfig(db, Config{
 		SampleInterval: 5 * time.Second,
 		MaxTemp:        90.0,
 		MaxMem:         95.0,
+		Idle:           detect.DefaultIdleConfig,
+		Leak:           detect.DefaultLeakConfig,
 	})
 }
 
-func NewWithConfig(db *store.DB, config Config) model {
-	loc := time.Now().Location()
+func NewWithConfig(db store.Store, config Config) model {
+	if config.Location == nil {
+		config.Location = time.Local
+	}
 	return model{
 		db:          db,
 		config:      config,
 		live:        true,
-		autoRecord:  true,
-		historyDate: time.Now().In(loc),
+		autoRecord:  !config.ReadOnly,
+		historyDate: time.Now().In(config.Location),
 		filterGPU:   -1, // show all GPUs by default
+		fleetView:   config.Fleet,
+		homeHost:    config.Host,
+		idle:        detect.NewIdleDetector(config.Idle),
+		leaks:       detect.NewLeakDetector(config.Leak),
 	}
 }
 
 func (m model) Init() tea.Cmd {
+	if m.fleetView {
+		return tea.Batch(m.refreshOnce(), m.tickIfNeeded(), m.loadFleetCmd())
+	}
 	return tea.Batch(m.refreshOnce(), m.tickIfNeeded())
 }
 
 func (m model) tickIfNeeded() tea.Cmd {
+	if m.live && m.config.ReadOnly {
+		return tea.Tick(m.config.SampleInterval, func(time.Time) tea.Msg { return m.tail() })
+	}
 	if m.live && m.autoRecord {
 		return tea.Tick(m.config.SampleInterval, func(time.Time) tea.Msg { return m.doSample() })
 	}
 	return nil
 }
 
+// followStatus describes the followed snapshot and how old it is, so a
+// stalled recorder is noticed.
+func (m model) followStatus() string {
+	s := fmt.Sprintf("FOLLOW %s (%s ago)", m.curr.TS.In(m.config.Location).Format("15:04:05.000"),
+		time.Since(m.curr.TS).Round(time.Second))
+	if p := m.config.Hosts; p != nil {
+		down := p.Down()
+		s += fmt.Sprintf(" | ssh %d/%d hosts up", p.Hosts()-len(down), p.Hosts())
+		if len(down) > 0 {
+			s += " | " + down[0].Error()
+		}
+		return s
+	}
+	return s + " | read-only"
+}
+
+// tail loads the newest stored snapshot when it differs from the shown one.
+func (m model) tail() tea.Msg {
+	s, err := m.db.LoadLatest(m.config.Host)
+	if err == store.ErrNoSnapshots {
+		return tailMsg{}
+	}
+	if err != nil {
+		return errorMsg{err}
+	}
+	if s.ID == m.curr.ID {
+		return tailMsg{}
+	}
+	return refreshMsg{snap: s}
+}
+
 func (m model) doSample() tea.Msg {
 	s, err := sampler.Sample()
 	if err != nil {
 		return errorMsg{err}
 	}
+	for _, b := range m.config.Sinks {
+		b.Enqueue(s)
+	}
 	// Save when auto record
 	if m.autoRecord {
+		if m.config.Writer != nil {
+			if err := m.config.Writer.Enqueue(s); err != nil {
+				return errorMsg{err}
+			}
+			return refreshMsg{snap: s}
+		}
 		id, err := m.db.SaveSnapshot(s)
 		if err != nil {
 			return errorMsg{err}
@@ -99,6 +194,10 @@ func (m model) doSample() tea.Msg {
 }
 
 func (m model) refreshOnce() tea.Cmd {
+	if m.config.ReadOnly {
+		m.curr.ID = 0 // always reload
+		return m.tail
+	}
 	return func() tea.Msg {
 		s, err := sampler.Sample()
 		if err != nil {
@@ -110,7 +209,7 @@ func (m model) refreshOnce() tea.Cmd {
 
 func (m model) loadMetasCmd(day time.Time) tea.Cmd {
 	return func() tea.Msg {
-		metas, err := m.db.ListSnapshotsByDate(day)
+		metas, err := m.db.ListSnapshotsByDate(day, m.config.Host)
 		if err != nil {
 			return errorMsg{err}
 		}
@@ -118,6 +217,20 @@ func (m model) loadMetasCmd(day time.Time) tea.Cmd {
 	}
 }
 
+func (m model) dayEnergyCmd(day time.Time) tea.Cmd {
+	return func() tea.Msg {
+		from, to := store.DayBounds(day)
+		if now := time.Now(); to.After(now) {
+			to = now
+		}
+		rep, err := energy.Compute(m.db, from, to, m.config.Host, m.config.Carbon, m.config.MaxGap)
+		if err != nil {
+			return errorMsg{err}
+		}
+		return energyMsg{rep: rep}
+	}
+}
+
 func (m model) loadByMetaCmd(idx int) tea.Cmd {
 	if idx < 0 || idx >= len(m.metas) {
 		return nil
@@ -132,20 +245,72 @@ func (m model) loadByMetaCmd(idx int) tea.Cmd {
 	}
 }
 
+// step moves the history cursor by dir. In compare mode it skips snapshots
+// of other hosts than the baseline's, since those cannot be diffed.
+func (m *model) step(dir int) {
+	for i := m.index + dir; i >= 0 && i < len(m.metas); i += dir {
+		if m.compareBase == nil || m.metas[i].Host == m.compareBase.Host.Hostname {
+			m.index = i
+			return
+		}
+	}
+}
+
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
 	case refreshMsg:
+		if h := m.config.Host; m.live && h != "" && msg.snap.Host.Hostname != "" && msg.snap.Host.Hostname != h {
+			return m, m.tickIfNeeded() // queued before drilling down into another host
+		}
 		m.curr = msg.snap
 		if m.live {
-			m.status = fmt.Sprintf("LIVE %s | autosave:%v", m.curr.TS.Format("15:04:05"), m.autoRecord)
+			m.idleAllocs = m.idle.Observe(m.curr)
+			m.growth = make(map[procRef]detect.Growth)
+			for _, g := range m.leaks.Observe(m.curr) {
+				m.growth[procRef{g.GPUUUID, g.PID}] = g
+			}
+			if m.config.ReadOnly {
+				m.status = m.followStatus()
+			} else {
+				m.status = fmt.Sprintf("LIVE %s | autosave:%v", m.curr.TS.In(m.config.Location).Format("15:04:05.000"), m.autoRecord)
+			}
+			if d := m.curr.Duration; d > 0 {
+				m.status += fmt.Sprintf(" | sample %s", d.Round(time.Millisecond))
+			}
+			if w := m.config.Writer; w != nil {
+				st := w.Stats()
+				m.status += fmt.Sprintf(" | queue %d/%d", st.Queued, st.Capacity)
+				if st.Errors > 0 {
+					m.status += fmt.Sprintf(" | write errors %d (%v)", st.Errors, st.LastErr)
+				}
+			}
+			for _, b := range m.config.Sinks {
+				if st := b.Stats(); st.Errors > 0 {
+					m.status += fmt.Sprintf(" | %s errors %d (%v)", b.Name, st.Errors, st.LastErr)
+				}
+			}
 		} else {
-			m.status = fmt.Sprintf("HISTORY %s (%d/%d)", m.curr.TS.Format("2006-01-02 15:04:05"), m.index+1, len(m.metas))
+			m.status = fmt.Sprintf("HISTORY %s (%d/%d)", m.curr.TS.In(m.config.Location).Format("2006-01-02 15:04:05.000 MST"), m.index+1, len(m.metas))
+			if m.compareBase != nil {
+				m.status += fmt.Sprintf(" | compare vs #%d", m.compareBase.ID)
+			}
+		}
+		if h := m.curr.Host.Hostname; h != "" {
+			m.status = h + " | " + m.status
 		}
 		m.err = nil
 		return m, m.tickIfNeeded()
+	case tailMsg:
+		if m.live && !m.curr.TS.IsZero() {
+			m.status = m.followStatus()
+			if h := m.curr.Host.Hostname; h != "" {
+				m.status = h + " | " + m.status
+			}
+		}
+		return m, m.tickIfNeeded()
 	case errorMsg:
 		m.err = msg.err
 		m.status = "error"
@@ -156,13 +321,35 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case metasMsg:
 		m.metas = msg.metas
 		m.index = 0
+		if m.jumpLast {
+			m.index = max(len(m.metas)-1, 0)
+			m.jumpLast = false
+		}
+		m.dayEnergy = nil
 		if len(m.metas) == 0 {
 			m.curr = types.Snapshot{}
 			m.status = "no snapshots on this date"
 			return m, nil
 		}
-		return m, m.loadByMetaCmd(m.index)
+		return m, tea.Batch(m.loadByMetaCmd(m.index), m.dayEnergyCmd(m.historyDate))
+	case energyMsg:
+		m.dayEnergy = &msg.rep
+		return m, nil
+	case fleetMsg:
+		if !m.fleetView || msg.gen != m.fleetGen {
+			return m, nil
+		}
+		m.err = msg.err
+		if msg.err == nil {
+			m.fleet = &msg.sum
+			m.fleetAt = time.Now()
+			m.fleetCursor = min(m.fleetCursor, max(len(msg.sum.Hosts)-1, 0))
+		}
+		return m, m.fleetTick()
 	case tea.KeyMsg:
+		if m.fleetView {
+			return m.updateFleet(msg)
+		}
 		switch msg.String() {
 		case "q", "ctrl+c":
 			return m, tea.Quit
@@ -170,6 +357,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.showHelp = !m.showHelp
 			return m, nil
 		case "a": // toggle auto-recording
+			if m.config.ReadOnly {
+				return m, nil
+			}
 			m.autoRecord = !m.autoRecord
 			return m, m.tickIfNeeded()
 		case "r": // refresh once
@@ -178,7 +368,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return m, nil
 		case "s": // save snapshot immediately
-			if m.live {
+			if m.live && !m.config.ReadOnly {
 				return m, func() tea.Msg {
 					if m.curr.TS.IsZero() {
 						return errorMsg{fmt.Errorf("no current snapshot")}
@@ -191,39 +381,51 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			}
 			return m, nil
+		case "d": // compare mode: mark the current snapshot as baseline, or leave
+			if m.live {
+				return m, nil
+			}
+			if m.compareBase != nil {
+				m.compareBase = nil
+				return m, nil
+			}
+			if m.curr.TS.IsZero() {
+				return m, nil
+			}
+			base := m.curr
+			m.compareBase = &base
+			return m, nil
 		case "h": // toggle history mode
 			m.live = !m.live
+			m.compareBase = nil
 			if m.live {
 				return m, m.refreshOnce()
 			}
 			// entering history: load today metas
-			m.historyDate = time.Now()
+			m.historyDate = time.Now().In(m.config.Location)
 			return m, m.loadMetasCmd(m.historyDate)
 		case "t": // today/live
 			m.live = true
+			m.compareBase = nil
 			return m, m.refreshOnce()
 		case "left":
 			if !m.live && len(m.metas) > 0 {
-				if m.index > 0 {
-					m.index--
-				}
+				m.step(-1)
 				return m, m.loadByMetaCmd(m.index)
 			}
 		case "right":
 			if !m.live && len(m.metas) > 0 {
-				if m.index < len(m.metas)-1 {
-					m.index++
-				}
+				m.step(1)
 				return m, m.loadByMetaCmd(m.index)
 			}
 		case "up":
 			if !m.live {
-				m.historyDate = m.historyDate.AddDate(0, 0, -1)
+				m.historyDate = shiftDay(m.historyDate, -1)
 				return m, m.loadMetasCmd(m.historyDate)
 			}
 		case "down":
 			if !m.live {
-				m.historyDate = m.historyDate.AddDate(0, 0, 1)
+				m.historyDate = shiftDay(m.historyDate, 1)
 				return m, m.loadMetasCmd(m.historyDate)
 			}
 		case "f": // filter by user
@@ -267,6 +469,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			}
 			return m, nil
+		case "F": // fleet overview
+			return m.enterFleet()
 		case "m": // toggle sort by memory
 			m.sortByMem = !m.sortByMem
 			return m, nil
@@ -280,6 +484,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// shiftDay moves to noon n calendar days away, so DST changes never skip or
+// repeat a day.
+func shiftDay(day time.Time, n int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day()+n, 12, 0, 0, 0, day.Location())
+}
+
 func (m model) getUniqueUsers() []string {
 	seen := make(map[string]bool)
 	var users []string
@@ -0,0 +1,73 @@
+package tui
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gpuwatch/internal/store"
+	"gpuwatch/internal/types"
+)
+
+// TestCompareSkipsOtherHosts checks that compare mode in a history of
+// several hosts only steps to, and only diffs against, the baseline's host.
+func TestCompareSkipsOtherHosts(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)
+	t0 := day.Add(10 * time.Hour)
+	for i, h := range []string{"alpha", "beta", "alpha", "beta"} {
+		s := types.Snapshot{
+			TS:    t0.Add(time.Duration(i) * time.Minute),
+			Host:  types.Host{Hostname: h, MachineID: "m-" + h},
+			GPUs:  []types.GPU{{Index: 0, UUID: "GPU-" + h, MemUsedMB: float64(1000 * (i + 1))}},
+			Procs: []types.GPUProcess{{PID: 100, User: "alice", GPUUUID: "GPU-" + h, UsedMemMB: float64(1000 * (i + 1))}},
+		}
+		if _, err := db.SaveSnapshot(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewWithConfig(db, Config{SampleInterval: time.Second, ReadOnly: true})
+	m.live = false
+	m.historyDate = day
+	next, _ := m.Update(m.loadMetasCmd(day)())
+	m = next.(model)
+	next, _ = m.Update(m.loadByMetaCmd(m.index)())
+	m = next.(model)
+	if m.curr.Host.Hostname != "alpha" {
+		t.Fatalf("history starts on %q, want alpha", m.curr.Host.Hostname)
+	}
+
+	m, _ = key(m, "d")
+	m, cmd := key(m, "right")
+	if m.index != 2 {
+		t.Fatalf("right in compare mode moved to %d, want 2 (alpha's next snapshot)", m.index)
+	}
+	next, _ = m.Update(cmd())
+	m = next.(model)
+	if out := m.renderDiff(); !strings.Contains(out, "Compare #") || !strings.Contains(out, "+2000 MB") {
+		t.Errorf("diff alpha → alpha:\n%s", out)
+	}
+	if m, _ = key(m, "right"); m.index != 2 {
+		t.Errorf("right past alpha's last snapshot moved to %d, want to stay at 2", m.index)
+	}
+
+	// A snapshot of another host reached some other way is not diffed.
+	next, _ = m.Update(m.loadByMetaCmd(1)())
+	m = next.(model)
+	if out := m.renderDiff(); !strings.Contains(out, "different hosts") {
+		t.Errorf("diff alpha → beta:\n%s", out)
+	}
+
+	// Without a baseline, browsing visits every host again.
+	m, _ = key(m, "d")
+	m.index = 0
+	if m, _ = key(m, "right"); m.index != 1 {
+		t.Errorf("right outside compare mode moved to %d, want 1", m.index)
+	}
+}
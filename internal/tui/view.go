@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
+	"gpuwatch/internal/diff"
 	"gpuwatch/internal/types"
 
 	lg "github.com/charmbracelet/lipgloss"
@@ -15,6 +17,10 @@ func (m model) View() string {
 		return "loading..."
 	}
 
+	if m.fleetView {
+		return m.viewFleet()
+	}
+
 	header := headStyle.Render("gpuwatch — per‑user GPU usage") + "  " + subtle.Render(m.status)
 	if m.err != nil {
 		header += "  " + errStyle.Render(m.err.Error())
@@ -35,12 +41,36 @@ func (m model) View() string {
 		header += "  " + lg.NewStyle().Foreground(lg.Color("#FFA500")).Render(fmt.Sprintf("[filters: %s]", strings.Join(filters, ", ")))
 	}
 
+	if !m.live && m.dayEnergy != nil {
+		header += "\n" + m.renderDayEnergy()
+	}
+
 	body := m.renderBody()
 	help := m.renderHelp()
 
 	return header + "\n\n" + body + "\n\n" + help
 }
 
+func (m model) renderDayEnergy() string {
+	rep := m.dayEnergy
+	total := rep.Total()
+	line := fmt.Sprintf("day energy %.2f kWh (idle %.2f)", total.EnergyKWh, rep.Unattributed.EnergyKWh)
+	if m.config.Carbon.Enabled() {
+		line += fmt.Sprintf(" · %.2f kg CO2e", total.CarbonKg)
+	}
+	var top []string
+	for i, u := range rep.Users {
+		if i == 3 {
+			break
+		}
+		top = append(top, fmt.Sprintf("%s %.2f", u.Key, u.EnergyKWh))
+	}
+	if len(top) > 0 {
+		line += " · top: " + strings.Join(top, ", ")
+	}
+	return subtle.Render(line)
+}
+
 func (m model) renderBody() string {
 	left := m.renderGPUs()
 	right := m.renderUsers()
@@ -48,9 +78,31 @@ func (m model) renderBody() string {
 
 	// layout: two columns top, then bottom full width
 	row := lg.JoinHorizontal(lg.Top, left, right)
+	if m.live && len(m.idleAllocs) > 0 {
+		bottom += "\n" + m.renderIdle()
+	}
+	if !m.live && m.compareBase != nil {
+		bottom += "\n" + m.renderDiff()
+	}
 	return row + "\n" + bottom
 }
 
+func (m model) renderIdle() string {
+	var b strings.Builder
+	b.WriteString(errStyle.Render(fmt.Sprintf("Idle GPU allocations (≥%.0f MB at ≤%.0f%% util)", m.config.Idle.MinMemMB, m.config.Idle.MaxUtil)) + "\n")
+	for _, a := range m.idleAllocs {
+		if m.filterUser != "" && !strings.EqualFold(a.User, m.filterUser) {
+			continue
+		}
+		if m.filterGPU != -1 && a.GPUIndex != m.filterGPU {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("%5d  %-12s  %-22s  %6.0f MB  GPU %d  idle %s\n",
+			a.PID, a.User, trim(a.ProcessName, 22), a.MemMB, a.GPUIndex, a.Duration().Round(time.Minute)))
+	}
+	return box.Width(m.width - 4).Render(b.String())
+}
+
 func (m model) renderGPUs() string {
 	snap := m.getFilteredSnapshot()
 	if len(snap.GPUs) == 0 {
@@ -70,6 +122,13 @@ func (m model) renderGPUs() string {
 		if g.UtilMem > m.config.MaxMem {
 			alerts = append(alerts, fmt.Sprintf("⚠️  HIGH MEM %.0f%%", g.UtilMem))
 		}
+		if m.live {
+			for _, gr := range m.growth {
+				if gr.Leaking && gr.GPUUUID == g.UUID {
+					alerts = append(alerts, fmt.Sprintf("⚠️  LEAK pid %d OOM ~%s", gr.PID, gr.TimeToOOM.Round(time.Minute)))
+				}
+			}
+		}
 
 		lines = append(lines, title)
 		lines = append(lines, drawBar(g.UtilGPU, 100, 24))
@@ -142,24 +201,85 @@ func (m model) renderProcs() string {
 	}
 	for i := 0; i < maxN; i++ {
 		p := procs[i]
-		b.WriteString(fmt.Sprintf("%5d  %-12s  %-22s  %6.0f MB  %s\n", p.PID, p.User, trim(p.ProcessName, 22), p.UsedMemMB, shortUUID(p.GPUUUID)))
+		line := fmt.Sprintf("%5d  %-12s  %-22s  %6.0f MB  %s", p.PID, p.User, trim(p.ProcessName, 22), p.UsedMemMB, shortUUID(p.GPUUUID))
+		if gr, ok := m.growth[procRef{p.GPUUUID, p.PID}]; ok && m.live {
+			trend := fmt.Sprintf("  %+6.0f MB/h", gr.RateMBPerH)
+			if gr.Leaking {
+				trend += fmt.Sprintf("  OOM ~%s", gr.TimeToOOM.Round(time.Minute))
+				line = errStyle.Render(line + trend)
+			} else {
+				line += subtle.Render(trend)
+			}
+		}
+		b.WriteString(line + "\n")
 	}
 	return box.Width(m.width - 4).Render(b.String())
 }
 
+// renderDiff shows what changed from the compare baseline to the viewed snapshot.
+func (m model) renderDiff() string {
+	d, err := diff.Compare(*m.compareBase, m.curr)
+	if err != nil {
+		return errStyle.Render(fmt.Sprintf("Compare: %v", err))
+	}
+	var b strings.Builder
+	b.WriteString(label.Render(fmt.Sprintf("Compare #%d %s → #%d %s (%s)", d.FromID, d.From.In(m.config.Location).Format("15:04:05"),
+		d.ToID, d.To.In(m.config.Location).Format("15:04:05"), d.Elapsed.Round(time.Second))) + "\n")
+	for _, g := range d.GPUs {
+		if m.filterGPU != -1 && g.Index != m.filterGPU {
+			continue
+		}
+		line := fmt.Sprintf("GPU %d  util %+4.0f%%  mem %+7.0f MB  temp %+3.0f°C  power %+4.0f W",
+			g.Index, g.UtilGPU.Change, g.MemMB.Change, g.TempC.Change, g.PowerW.Change)
+		if g.Status != "" {
+			line += "  " + g.Status
+		}
+		b.WriteString(line + "\n")
+	}
+	procLine := func(sign string, p diff.ProcChange) {
+		if m.filterUser != "" && !strings.EqualFold(p.User, m.filterUser) {
+			return
+		}
+		if m.filterGPU != -1 && p.GPUIndex != m.filterGPU {
+			return
+		}
+		b.WriteString(fmt.Sprintf("%s %5d  %-12s  %-22s  GPU %d  %+7.0f MB\n", sign, p.PID, p.User, trim(p.ProcessName, 22), p.GPUIndex, p.MemMB.Change))
+	}
+	for _, p := range d.Started {
+		procLine("+", p)
+	}
+	for _, p := range d.Ended {
+		procLine("-", p)
+	}
+	for _, p := range d.Changed {
+		procLine("~", p)
+	}
+	var users []string
+	for _, u := range d.Users {
+		if u.MemMB.Change != 0 || u.Status != "" {
+			users = append(users, fmt.Sprintf("%s %+.0f MB", u.User, u.MemMB.Change))
+		}
+	}
+	if len(users) > 0 {
+		b.WriteString(subtle.Render("users: " + strings.Join(users, ", ")))
+	}
+	return box.Width(m.width - 4).Render(strings.TrimRight(b.String(), "\n"))
+}
+
 func (m model) renderHelp() string {
 	if !m.showHelp {
-		return subtle.Render("a: auto | r: refresh | s: save | h: history | f: filter user | g: filter GPU | c: clear | ?: help | q: quit")
+		return subtle.Render("a: auto | r: refresh | s: save | h: history | d: compare | f: filter user | g: filter GPU | c: clear | F: fleet | ?: help | q: quit")
 	}
 	return box.Width(m.width - 4).Render(strings.Join([]string{
 		"Navigation & Actions:",
-		"  a — Toggle auto-recording of live samples",
+		"  a — Toggle auto-recording of live samples (not with -readonly)",
 		"  r — Refresh once (live mode)",
-		"  s — Save the current snapshot immediately",
+		"  s — Save the current snapshot immediately (not with -readonly)",
 		"  h — Toggle History mode",
 		"  ←/→ — Previous/Next snapshot of the selected date",
 		"  ↑/↓ — Move one day back/forward",
 		"  t — Jump back to today and live mode",
+		"  d — Compare: mark the shown snapshot as baseline, then browse to diff against it (d again to leave)",
 		"",
 		"Filters & Display:",
 		"  f — Cycle through users to filter by specific user",
@@ -167,6 +287,8 @@ func (m model) renderHelp() string {
 		"  m — Toggle sort processes by memory usage",
 		"  c — Clear all active filters",
 		"",
+		"  F — Fleet overview of every host in the history",
+		"",
 		"  q — Quit",
 	}, "\n"))
 }
@@ -25,10 +25,27 @@ type GPUProcess struct {
 	User        string // resolved from UID
 }
 
+// Host identifies the machine a snapshot was taken on.
+type Host struct {
+	Hostname  string
+	MachineID string            // /etc/machine-id when available
+	Labels    map[string]string // user-defined, e.g. rack=a3
+}
+
+// Key is a stable identity for the host: machine ID when known, else hostname.
+func (h Host) Key() string {
+	if h.MachineID != "" {
+		return h.MachineID
+	}
+	return h.Hostname
+}
+
 // Snapshot is a full capture of system GPUs and processes at a moment.
 type Snapshot struct {
 	ID       int64
-	TS       time.Time
+	TS       time.Time     // when sampling started, nanosecond precision
+	Duration time.Duration // how long sampling took
+	Host     Host
 	GPUs     []GPU
 	Procs    []GPUProcess
 }
@@ -56,3 +56,50 @@ func BuildUIDMap() map[string]string {
 	}
 	return m
 }
+
+// BuildUserGroupMap returns username->primary group name using /etc/passwd and /etc/group.
+func BuildUserGroupMap() map[string]string {
+	groups := make(map[string]string) // gid -> name
+	if f, err := os.Open("/etc/group"); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			parts := strings.Split(s.Text(), ":")
+			if len(parts) < 3 || strings.HasPrefix(parts[0], "#") {
+				continue
+			}
+			groups[parts[2]] = parts[0]
+		}
+		f.Close()
+	}
+	m := make(map[string]string)
+	f, err := os.Open("/etc/passwd")
+	if err != nil {
+		return m
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		parts := strings.Split(s.Text(), ":")
+		if len(parts) < 4 || strings.HasPrefix(parts[0], "#") {
+			continue
+		}
+		if g, ok := groups[parts[3]]; ok {
+			m[parts[0]] = g
+		} else {
+			m[parts[0]] = "gid:" + parts[3]
+		}
+	}
+	return m
+}
+
+// ReadMachineID returns the systemd/dbus machine ID, or "" if unavailable.
+func ReadMachineID() string {
+	for _, p := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if b, err := os.ReadFile(p); err == nil {
+			if id := strings.TrimSpace(string(b)); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
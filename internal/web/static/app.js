@@ -0,0 +1,303 @@
+// gpuwatch dashboard: live view follows /api/v1/stream (polling
+// /api/v1/snapshot/latest where SSE is unavailable), history view walks the
+// snapshots of one day. Both draw the same panels as the TUI.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+const palette = ["#0f62fe", "#da1e28", "#198038", "#8a3ffc", "#ff832b", "#1192e8", "#fa4d56", "#6fdc8c"];
+
+const state = {
+  cfg: { RefreshMS: 2000, MaxTemp: 85, MaxMem: 90 },
+  live: true,
+  host: "",
+  day: "",
+  metas: [],
+  timer: null,
+  chartTimer: null,
+  events: null,
+  zone: null, // server UTC offset in minutes, learned from timestamps
+};
+
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, (c) => ({ "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;" }[c]));
+}
+
+async function api(path, params) {
+  const q = new URLSearchParams();
+  for (const [k, v] of Object.entries(params || {})) {
+    if (v !== "" && v !== undefined && v !== null) q.set(k, v);
+  }
+  const res = await fetch(path + (q.toString() ? "?" + q : ""));
+  const body = await res.json();
+  if (!res.ok) throw new Error(body.error || res.statusText);
+  return body;
+}
+
+// Times come from the server in its -tz zone; show them as written and
+// compute days and chart axes in that zone too.
+const clock = (ts) => ts.slice(11, 23);
+function learnZone(ts) {
+  const m = /(Z|([+-])(\d\d):(\d\d))$/.exec(ts);
+  if (m) state.zone = m[1] === "Z" ? 0 : (m[2] === "-" ? -1 : 1) * (60 * Number(m[3]) + Number(m[4]));
+}
+const zoneMS = () => (state.zone === null ? -new Date().getTimezoneOffset() : state.zone) * 60000;
+const today = () => new Date(Date.now() + zoneMS()).toISOString().slice(0, 10);
+const midnight = (day) => new Date(Date.parse(day + "T00:00:00Z") - zoneMS());
+const addDays = (day, n) => new Date(Date.parse(day + "T00:00:00Z") + n * 86400000).toISOString().slice(0, 10);
+
+function showError(err) {
+  $("error").textContent = err ? String(err.message || err) : "";
+}
+
+function bar(value, max, text) {
+  const pct = max > 0 ? Math.min(100, (100 * value) / max) : 0;
+  return `<div class="bar"><div style="width:${pct.toFixed(1)}%"></div><span>${esc(text)}</span></div>`;
+}
+
+function render(snap) {
+  const gpus = snap.GPUs || [];
+  const procs = snap.Procs || [];
+  const busy = new Set(procs.map((p) => p.GPUUUID));
+
+  $("gpu-list").innerHTML = gpus.length ? gpus.map((g) => {
+    const alerts = [];
+    if (g.TempC > state.cfg.MaxTemp) alerts.push(`HIGH TEMP ${g.TempC.toFixed(0)}°C`);
+    if (g.UtilMem > state.cfg.MaxMem) alerts.push(`HIGH MEM ${g.UtilMem.toFixed(0)}%`);
+    return `<div class="gpu${busy.has(g.UUID) ? "" : " free"}">
+      <div class="gpu-title">GPU ${g.Index} — ${esc(g.Name)}</div>
+      ${bar(g.UtilGPU, 100, `util ${g.UtilGPU.toFixed(0)}%`)}
+      ${bar(g.MemUsedMB, g.MemTotalMB, `mem ${g.MemUsedMB.toFixed(0)}/${g.MemTotalMB.toFixed(0)} MB`)}
+      <div class="muted">temp ${g.TempC.toFixed(0)}°C | power ${g.PowerDrawW.toFixed(0)}/${g.PowerLimitW.toFixed(0)} W</div>
+      ${alerts.length ? `<div class="danger">⚠ ${alerts.join(" ")}</div>` : ""}
+    </div>`;
+  }).join("") : `<p class="muted">no GPU data</p>`;
+
+  const users = new Map();
+  for (const p of procs) users.set(p.User, (users.get(p.User) || 0) + p.UsedMemMB);
+  const sorted = [...users].sort((a, b) => b[1] - a[1]);
+  const max = Math.max(1, ...sorted.map((u) => u[1]));
+  $("user-list").innerHTML = sorted.length ? sorted.map(([u, mb]) =>
+    `<div class="user"><span>${esc(u)}</span>${bar(mb, max, "")}<span class="num">${mb.toFixed(0)}</span></div>`
+  ).join("") : `<p class="muted">no running GPU processes</p>`;
+
+  const index = new Map(gpus.map((g) => [g.UUID, g.Index]));
+  $("proc-list").innerHTML = procs.length ? [...procs].sort((a, b) => b.UsedMemMB - a.UsedMemMB).slice(0, 10).map((p) =>
+    `<tr><td class="num">${p.PID}</td><td>${esc(p.User)}</td><td>${esc(p.ProcessName)}</td>` +
+    `<td class="num">${p.UsedMemMB.toFixed(0)} MB</td><td>${index.has(p.GPUUUID) ? index.get(p.GPUUUID) : esc(p.GPUUUID)}</td></tr>`
+  ).join("") : `<tr><td colspan="5" class="muted">none</td></tr>`;
+
+  learnZone(snap.TS);
+  const host = snap.Host && snap.Host.Hostname ? ` on ${snap.Host.Hostname}` : "";
+  const dur = snap.Duration ? ` | sample ${(snap.Duration / 1e6).toFixed(0)} ms` : "";
+  $("status").textContent = state.live
+    ? `LIVE${host} ${clock(snap.TS)}${dur}`
+    : `HISTORY${host} ${snap.TS.slice(0, 10)} ${clock(snap.TS)} (#${snap.ID})`;
+}
+
+function drawChart(canvas, series, yMax, from, to) {
+  const dpr = window.devicePixelRatio || 1;
+  const w = canvas.clientWidth, h = canvas.clientHeight || 180;
+  canvas.width = w * dpr;
+  canvas.height = h * dpr;
+  const ctx = canvas.getContext("2d");
+  ctx.scale(dpr, dpr);
+  ctx.clearRect(0, 0, w, h);
+  const style = getComputedStyle(document.body);
+  const pad = { l: 48, r: 8, t: 8, b: 20 };
+  const t0 = from.getTime(), t1 = to.getTime();
+  const x = (t) => pad.l + ((t - t0) / (t1 - t0)) * (w - pad.l - pad.r);
+  const y = (v) => h - pad.b - (v / yMax) * (h - pad.t - pad.b);
+
+  ctx.strokeStyle = style.getPropertyValue("--border");
+  ctx.fillStyle = style.getPropertyValue("--muted");
+  ctx.font = "11px system-ui";
+  ctx.lineWidth = 1;
+  for (let i = 0; i <= 4; i++) {
+    const v = (yMax * i) / 4;
+    ctx.beginPath();
+    ctx.moveTo(pad.l, y(v));
+    ctx.lineTo(w - pad.r, y(v));
+    ctx.stroke();
+    ctx.fillText(v.toFixed(0), 4, y(v) + 4);
+  }
+  for (let i = 0; i <= 6; i++) {
+    const t = new Date(t0 + ((t1 - t0) * i) / 6);
+    const label = new Date(t.getTime() + zoneMS()).toISOString().slice(11, 16);
+    ctx.fillText(label, Math.min(x(t.getTime()) - 14, w - 34), h - 4);
+  }
+
+  ctx.lineWidth = 1.5;
+  series.forEach((s, i) => {
+    ctx.strokeStyle = palette[i % palette.length];
+    ctx.beginPath();
+    s.Points.forEach((p, j) => {
+      const px = x(Date.parse(p.TS)), py = y(p.Value);
+      if (j === 0) ctx.moveTo(px, py); else ctx.lineTo(px, py);
+    });
+    ctx.stroke();
+  });
+}
+
+async function loadCharts() {
+  let params, from, to;
+  if (state.live) {
+    to = new Date();
+    from = new Date(to.getTime() - 3600 * 1000);
+    params = { host: state.host, step: "30s" };
+    $("chart-range").textContent = "last hour";
+  } else {
+    params = { host: state.host, from: state.day, to: addDays(state.day, 1), step: "5m" };
+    from = midnight(state.day);
+    to = midnight(addDays(state.day, 1));
+    $("chart-range").textContent = state.day;
+  }
+  try {
+    const [util, mem] = await Promise.all([
+      api("/api/v1/series", { ...params, metric: "util" }),
+      api("/api/v1/series", { ...params, metric: "mem_used_mb" }),
+    ]);
+    const memMax = Math.max(1, ...mem.flatMap((s) => s.Points.map((p) => p.Value)));
+    drawChart($("chart-util"), util, 100, from, to);
+    drawChart($("chart-mem"), mem, memMax * 1.1, from, to);
+    $("legend").innerHTML = util.map((s, i) =>
+      `<span><i style="background:${palette[i % palette.length]}"></i>${esc(s.Host)} GPU ${s.Index}</span>`
+    ).join("");
+  } catch (err) {
+    $("chart-range").textContent = err.message;
+  }
+}
+
+async function pollLive() {
+  try {
+    render(await api("/api/v1/snapshot/latest", { host: state.host }));
+    showError(null);
+  } catch (err) {
+    showError(err);
+  }
+}
+
+function stopTimers() {
+  clearInterval(state.timer);
+  clearInterval(state.chartTimer);
+  if (state.events) state.events.close();
+  state.events = null;
+}
+
+function poll() {
+  pollLive();
+  state.timer = setInterval(pollLive, Math.max(500, state.cfg.RefreshMS));
+}
+
+// follow subscribes to the live stream; the browser reconnects by itself
+// after a drop, and polling takes over if the stream cannot be opened.
+function follow() {
+  if (!window.EventSource) return poll();
+  const es = new EventSource("/api/v1/stream?" + new URLSearchParams(state.host ? { host: state.host } : {}));
+  state.events = es;
+  es.addEventListener("snapshot", (e) => {
+    render(JSON.parse(e.data).Snapshot);
+    showError(null);
+  });
+  es.onerror = () => {
+    if (es.readyState === EventSource.CLOSED && state.events === es) {
+      state.events = null;
+      poll();
+    }
+  };
+}
+
+function goLive() {
+  stopTimers();
+  state.live = true;
+  $("live").classList.add("active");
+  $("timeline").hidden = true;
+  follow();
+  loadCharts();
+  state.chartTimer = setInterval(loadCharts, 30000);
+}
+
+async function goDay(day) {
+  stopTimers();
+  state.live = false;
+  state.day = day;
+  $("day").value = day;
+  $("live").classList.remove("active");
+  $("timeline").hidden = false;
+  showError(null);
+  try {
+    const metas = [];
+    for (let offset = 0; ; offset += 1000) {
+      const page = await api("/api/v1/snapshots", { date: day, host: state.host, limit: 1000, offset });
+      metas.push(...page.Items);
+      if (offset + page.Limit >= page.Total) break;
+    }
+    state.metas = metas;
+    $("slider").max = Math.max(0, metas.length - 1);
+    $("slider").value = Math.max(0, metas.length - 1);
+    if (metas.length) {
+      await showSnapshot(metas.length - 1);
+    } else {
+      $("slider-label").textContent = "";
+      render({ GPUs: [], Procs: [], TS: "" });
+      $("status").textContent = `HISTORY ${day}: no snapshots`;
+    }
+  } catch (err) {
+    showError(err);
+  }
+  loadCharts();
+}
+
+async function showSnapshot(i) {
+  const meta = state.metas[i];
+  if (!meta) return;
+  $("slider-label").textContent = `${i + 1}/${state.metas.length} ${clock(meta.TS)}`;
+  try {
+    render(await api(`/api/v1/snapshots/${meta.ID}`));
+  } catch (err) {
+    showError(err);
+  }
+}
+
+async function loadHosts() {
+  try {
+    const hosts = await api("/api/v1/hosts");
+    for (const h of hosts) {
+      const o = document.createElement("option");
+      o.value = o.textContent = h.Hostname;
+      $("host").appendChild(o);
+    }
+  } catch (err) {
+    // no history store: live view only
+    for (const id of ["prev", "next", "day"]) $(id).disabled = true;
+    $("charts").hidden = true;
+  }
+}
+
+async function main() {
+  try {
+    state.cfg = await api("/config.json");
+  } catch (err) {
+    showError(err);
+  }
+  $("day").value = today();
+  $("live").onclick = goLive;
+  $("prev").onclick = () => goDay(addDays(state.day || today(), -1));
+  $("next").onclick = () => goDay(addDays(state.day || today(), 1));
+  $("day").onchange = () => $("day").value && goDay($("day").value);
+  $("slider").oninput = () => showSnapshot(Number($("slider").value));
+  $("host").onchange = () => {
+    state.host = $("host").value;
+    if (state.live) goLive(); else goDay(state.day);
+  };
+  document.addEventListener("keydown", (e) => {
+    if (e.target.tagName === "INPUT" || e.target.tagName === "SELECT") return;
+    if (e.key === "ArrowLeft" && !state.live) $("prev").click();
+    if (e.key === "ArrowRight" && !state.live) $("next").click();
+    if (e.key === "t") goDay(today());
+    if (e.key === "l") goLive();
+  });
+  await loadHosts();
+  goLive();
+}
+
+main();
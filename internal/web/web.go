@@ -0,0 +1,37 @@
+// Package web serves the browser dashboard, a single page embedded in the
+// binary that reads everything from the JSON API.
+package web
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+//go:embed static
+var static embed.FS
+
+// Config is passed to the page as /config.json.
+type Config struct {
+	Refresh time.Duration // how often the live view polls
+	MaxTemp float64       // alert thresholds, as in the TUI
+	MaxMem  float64
+}
+
+// Handler serves the dashboard at / and its settings at /config.json.
+func Handler(cfg Config) http.Handler {
+	files, _ := fs.Sub(static, "static")
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServer(http.FS(files)))
+	mux.HandleFunc("GET /config.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"RefreshMS": cfg.Refresh.Milliseconds(),
+			"MaxTemp":   cfg.MaxTemp,
+			"MaxMem":    cfg.MaxMem,
+		})
+	})
+	return mux
+}